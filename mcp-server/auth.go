@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+)
+
+const authUsage = `usage: server auth <command> [flags]
+
+commands:
+  login   sign in to Google and store the token for an account
+  list    list accounts with a stored token`
+
+// runAuth implements the "auth" subcommand.
+func runAuth(args []string) error {
+	if len(args) == 0 {
+		return errors.New(authUsage)
+	}
+	switch args[0] {
+	case "login":
+		return runAuthLogin(args[1:])
+	case "list":
+		return runAuthList(args[1:])
+	default:
+		return fmt.Errorf("unknown auth command %q\n%s", args[0], authUsage)
+	}
+}
+
+func runAuthLogin(args []string) error {
+	fs := flag.NewFlagSet("auth login", flag.ContinueOnError)
+	envFile := fs.String("env", ".env", "Path to environment file")
+	account := fs.String("account", services.DefaultAccount, "Name of the account to store the token under")
+	profiles := fs.String("scopes", "", "Comma separated scope profiles to request (default: those of the enabled tools): "+
+		strings.Join(services.ProfileNames(), ", "))
+	user := fs.String("user", "", "Store the token as this user's google_token in the session store instead of a token file")
+	listen := fs.String("listen", "127.0.0.1:0", "Loopback address for the OAuth redirect")
+	noBrowser := fs.Bool("no-browser", false, "Print the consent URL instead of opening a browser")
+	timeout := fs.Duration("timeout", 5*time.Minute, "How long to wait for consent")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	godotenv.Load(*envFile)
+
+	if err := services.ValidateAccount(*account); err != nil {
+		return err
+	}
+	names := registerTools(server.NewMCPServer("auth", "")).ScopeProfiles()
+	if *profiles != "" {
+		names = strings.Split(*profiles, ",")
+	}
+	scopes, err := services.ProfileScopes(names)
+	if err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		return errors.New("no enabled tool needs Google access; pass -scopes to choose profiles")
+	}
+	fmt.Fprintf(os.Stderr, "Requesting scopes for %s:\n  %s\n", strings.Join(names, ", "), strings.Join(scopes, "\n  "))
+	paths := services.GooglePathsFromEnv()
+	config, err := services.GoogleConfig(paths.CredentialsFile, scopes...)
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, services.GoogleErrorHint(err))
+	}
+
+	tok, err := services.LoginLoopback(context.Background(), config, services.LoginOptions{
+		ListenAddr: *listen,
+		Timeout:    *timeout,
+		OpenURL: func(url string) {
+			fmt.Fprintf(os.Stderr, "Open the following link in your browser to authorize access:\n%s\n", url)
+			if !*noBrowser {
+				openBrowser(url)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if *user != "" {
+		store, err := session.StoreFromEnv()
+		if err != nil {
+			return err
+		}
+		b, err := services.EncodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := store.Set(*user, session.ServiceGoogleToken, string(b), 0); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Token stored as google_token of user %s.\n", *user)
+		return nil
+	}
+
+	tokenFile := paths.TokenFile(*account)
+	if err := (services.FileTokenStore{Path: tokenFile}).Save(tok); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Token for account %s saved to %s.\n", *account, tokenFile)
+	return nil
+}
+
+func runAuthList(args []string) error {
+	fs := flag.NewFlagSet("auth list", flag.ContinueOnError)
+	envFile := fs.String("env", ".env", "Path to environment file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	godotenv.Load(*envFile)
+
+	paths := services.GooglePathsFromEnv()
+	accounts, err := paths.Accounts()
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		fmt.Println("No accounts signed in.")
+		return nil
+	}
+	for _, account := range accounts {
+		fmt.Printf("%s\t%s\n", account, paths.TokenFile(account))
+	}
+	return nil
+}
+
+// openBrowser tries to open url with the platform's default handler.
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open a browser (%v); open the link manually.\n", err)
+	}
+}
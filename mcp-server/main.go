@@ -3,45 +3,133 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/mark3labs/mcp-go/server"
 
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
 	"github.com/dongsinhho/ai-repos/mcp-server/tools"
+	"github.com/dongsinhho/ai-repos/mcp-server/transport"
 )
 
+// envOr returns the value of the environment variable key, or fallback when unset.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// registerTools adds every tool group to s. ENABLE_TOOLS, DISABLE_TOOLS and
+// TOOL_PROFILE decide what is advertised in tools/list.
+func registerTools(s *server.MCPServer) *tools.Registry {
+	registry := tools.NewRegistry(s, tools.FilterFromEnv())
+	tools.RegisterMailTools(registry.Group(tools.GroupMail))
+	tools.RegisterFilesystemTools(registry.Group(tools.GroupFilesystem))
+	tools.RegisterSessionTools(registry.Group(tools.GroupSession))
+	tools.RegisterConfirmTools(registry.Group(tools.GroupSession))
+	return registry
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		if err := runAuth(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	envFile := flag.String("env", ".env", "Path to environment file")
+	transportFlag := flag.String("transport", "", "Transport to serve: stdio, sse or http (env MCP_TRANSPORT, default sse)")
+	addr := flag.String("addr", "", "Listen address for sse/http transports (env MCP_ADDR, default :8082)")
+	baseURL := flag.String("base-url", "", "Public base URL for the sse transport (env MCP_BASE_URL, default http://localhost:8082)")
+	basePath := flag.String("base-path", "", "Path prefix for sse/http endpoints (env MCP_BASE_PATH)")
+	userHeader := flag.String("user-header", "", "Trusted header identifying the caller for per-user credentials (env MCP_USER_HEADER)")
+	idleTimeout := flag.String("session-idle-timeout", "", "End http transport sessions idle this long, 0 to keep them (env MCP_SESSION_IDLE_TIMEOUT, default 30m)")
 	flag.Parse()
 
 	if err := godotenv.Load(*envFile); err != nil {
 		//fmt.Printf("Warning: Error loading env file %s: %v\n", *envFile, err)
 	}
+
+	// Flags win over the environment so the same .env can serve several transports.
+	cfg := transport.Config{
+		Transport:  *transportFlag,
+		Addr:       *addr,
+		BaseURL:    *baseURL,
+		BasePath:   *basePath,
+		UserHeader: *userHeader,
+	}
+	if cfg.Transport == "" {
+		cfg.Transport = envOr("MCP_TRANSPORT", transport.SSE)
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = envOr("MCP_ADDR", ":8082")
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = envOr("MCP_BASE_URL", "http://localhost:8082")
+	}
+	if cfg.BasePath == "" {
+		cfg.BasePath = os.Getenv("MCP_BASE_PATH")
+	}
+	if cfg.UserHeader == "" {
+		cfg.UserHeader = os.Getenv("MCP_USER_HEADER")
+	}
+	if *idleTimeout == "" {
+		*idleTimeout = envOr("MCP_SESSION_IDLE_TIMEOUT", "30m")
+	}
+	timeout, err := time.ParseDuration(*idleTimeout)
+	if err != nil || timeout < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid session idle timeout %q\n", *idleTimeout)
+		os.Exit(1)
+	}
+	cfg.SessionIdleTimeout = timeout
+
+	store, err := session.StoreFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Session store error: %v\n", err)
+		os.Exit(1)
+	}
+	session.SetStore(store)
+
+	// Credentials bound to a client session die with it.
+	hooks := &server.Hooks{}
+	hooks.AddOnUnregisterSession(session.Forget)
+	hooks.AddOnUnregisterSession(tools.ForgetSendQuota)
+	hooks.AddOnUnregisterSession(tools.ForgetHistoryCursor)
+	hooks.AddOnUnregisterSession(tools.ForgetMailCache)
+
 	mcpServer := server.NewMCPServer(
 		"Demo",
 		"1.0.0",
+		server.WithHooks(hooks),
 		server.WithLogging(),
 		server.WithPromptCapabilities(true),
 		server.WithResourceCapabilities(true, true),
 	)
 
-	// enableTools := strings.Split(os.Getenv("ENABLE_TOOLS"), ",")
-	// allToolsEnabled := len(enableTools) == 1 && enableTools[0] == ""
-
-	// isEnabled := func(toolName string) bool {
-	// 	return allToolsEnabled || slices.Contains(enableTools, toolName)
-	// }
-
-	tools.RegisterMailTools(mcpServer)
-	tools.RegisterFilesystemTools(mcpServer)
+	// Network transports serve several people, so nobody borrows the
+	// server-wide Google token unless GOOGLE_SHARED_TOKEN says so.
+	tools.SharedGoogleToken = cfg.Transport == transport.Stdio
+	if v, err := strconv.ParseBool(os.Getenv("GOOGLE_SHARED_TOKEN")); err == nil {
+		tools.SharedGoogleToken = v
+	}
 
-	// if err := server.ServeStdio(mcpServer); err != nil {
-	// 	panic(fmt.Sprintf("Server error: %v", err))
-	// }
-	sse := server.NewSSEServer(mcpServer,
-		server.WithBaseURL("http://localhost:8082"))
-	err := sse.Start(":8082")
+	registry := registerTools(mcpServer)
+	scopes, err := services.ProfileScopes(registry.ScopeProfiles())
 	if err != nil {
-		fmt.Printf("Server error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Scope error: %v\n", err)
+		os.Exit(1)
+	}
+	services.DefaultScopes = scopes
+
+	if err := transport.Serve(mcpServer, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// ClientCache keeps one API client per user. An entry is rebuilt when the
+// credential fingerprint it was built from changes, and evicted when idle
+// for longer than the TTL or when the cache is full (least recently used first).
+type ClientCache[T any] struct {
+	mu         sync.Mutex
+	maxEntries int
+	idleTTL    time.Duration
+	entries    map[string]*cachedClient[T]
+}
+
+type cachedClient[T any] struct {
+	fingerprint string
+	client      T
+	lastUsed    time.Time
+}
+
+func NewClientCache[T any](maxEntries int, idleTTL time.Duration) *ClientCache[T] {
+	return &ClientCache[T]{
+		maxEntries: maxEntries,
+		idleTTL:    idleTTL,
+		entries:    make(map[string]*cachedClient[T]),
+	}
+}
+
+// Get returns the client cached for key if it was built from the same
+// fingerprint, otherwise it calls build and caches the result.
+func (c *ClientCache[T]) Get(key, fingerprint string, build func() (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.evictIdle(now)
+	if entry, ok := c.entries[key]; ok && entry.fingerprint == fingerprint {
+		entry.lastUsed = now
+		return entry.client, nil
+	}
+
+	client, err := build()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = &cachedClient[T]{fingerprint: fingerprint, client: client, lastUsed: now}
+	return client, nil
+}
+
+// Evict drops the client cached for key.
+func (c *ClientCache[T]) Evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *ClientCache[T]) evictIdle(now time.Time) {
+	if c.idleTTL <= 0 {
+		return
+	}
+	for key, entry := range c.entries {
+		if now.Sub(entry.lastUsed) > c.idleTTL {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *ClientCache[T]) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if oldestKey == "" || entry.lastUsed.Before(oldest) {
+			oldestKey, oldest = key, entry.lastUsed
+		}
+	}
+	delete(c.entries, oldestKey)
+}
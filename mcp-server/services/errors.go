@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// Google credential problems. Errors returned by this package wrap one of
+// these so callers can tell the user how to fix them.
+var (
+	ErrMissingCredentials = errors.New("google OAuth client credentials are missing or invalid")
+	ErrMissingToken       = errors.New("google token not found")
+	ErrTokenRevoked       = errors.New("google refresh token expired or was revoked")
+	ErrInsufficientScope  = errors.New("google token lacks a required scope")
+	ErrDelegationDenied   = errors.New("service account may not act as this user")
+)
+
+// ClassifyGoogleError maps OAuth and Google API failures onto the errors
+// above. Errors it does not recognise are returned unchanged.
+func ClassifyGoogleError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		switch retrieveErrorCode(retrieveErr) {
+		case "invalid_grant":
+			return fmt.Errorf("%w: %v", ErrTokenRevoked, err)
+		case "unauthorized_client":
+			return fmt.Errorf("%w: %v", ErrDelegationDenied, err)
+		}
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("%w: %v", ErrTokenRevoked, err)
+		case http.StatusForbidden:
+			for _, item := range apiErr.Errors {
+				if item.Reason == "insufficientPermissions" {
+					return fmt.Errorf("%w: %v", ErrInsufficientScope, err)
+				}
+			}
+			if strings.Contains(apiErr.Body, "ACCESS_TOKEN_SCOPE_INSUFFICIENT") {
+				return fmt.Errorf("%w: %v", ErrInsufficientScope, err)
+			}
+		}
+	}
+	return err
+}
+
+// retrieveErrorCode returns the OAuth error code of a failed token request.
+// The JWT flow used by service accounts leaves ErrorCode empty, so the code
+// is read from the response body.
+func retrieveErrorCode(err *oauth2.RetrieveError) string {
+	if err.ErrorCode != "" {
+		return err.ErrorCode
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(err.Body, &body)
+	return body.Error
+}
+
+// GoogleErrorHint returns what the user should do about a classified error,
+// or "" when there is nothing specific to suggest.
+func GoogleErrorHint(err error) string {
+	switch {
+	case errors.Is(err, ErrMissingCredentials):
+		return "Download the OAuth client JSON from the Google Cloud console and save it as the credentials file."
+	case errors.Is(err, ErrMissingToken):
+		return "Sign in to Google to create a token, or call set_api_key with service google_token."
+	case errors.Is(err, ErrTokenRevoked):
+		return "Sign in to Google again to obtain a new token; the old one can no longer be refreshed."
+	case errors.Is(err, ErrInsufficientScope):
+		return "Sign in to Google again and grant the scopes this tool needs."
+	case errors.Is(err, ErrDelegationDenied):
+		return "Check GOOGLE_DELEGATION_ALLOW, and that a Workspace admin authorized the service account's client ID for these scopes under domain-wide delegation."
+	}
+	return ""
+}
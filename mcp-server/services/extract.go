@@ -0,0 +1,312 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// ErrUnsupportedContent is returned by ExtractText for content it cannot
+// turn into text.
+var ErrUnsupportedContent = errors.New("no text can be extracted from this content type")
+
+// ExtractText returns the readable text of a file. The type is taken from
+// contentType, falling back to the filename extension: plain text, CSV,
+// JSON, HTML, e-mail messages (.eml) and the text layer of PDFs are
+// supported.
+func ExtractText(filename, contentType string, data []byte) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		mediaType = ""
+	}
+	ext := strings.ToLower(fileExt(filename))
+	switch {
+	case mediaType == "application/pdf" || ext == ".pdf":
+		return PDFText(data)
+	case mediaType == "message/rfc822" || ext == ".eml":
+		return messageText(data)
+	case mediaType == "text/html" || ext == ".html" || ext == ".htm":
+		return HTMLToText(DecodeCharset(data, params["charset"])), nil
+	case mediaType == "application/json" || ext == ".json":
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		return buf.String(), nil
+	case strings.HasPrefix(mediaType, "text/") || ext == ".txt" || ext == ".csv" || ext == ".md" || ext == ".log":
+		return DecodeCharset(data, params["charset"]), nil
+	}
+	return "", fmt.Errorf("%w (%s)", ErrUnsupportedContent, firstNonEmpty(mediaType, ext, "unknown type"))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// messageText renders an RFC 5322 message as its main headers followed by
+// its text body.
+func messageText(data []byte) (string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid e-mail message: %w", err)
+	}
+	var b strings.Builder
+	dec := new(mime.WordDecoder)
+	for _, name := range []string{"From", "To", "Cc", "Date", "Subject"} {
+		if value := msg.Header.Get(name); value != "" {
+			if decoded, err := dec.DecodeHeader(value); err == nil {
+				value = decoded
+			}
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+	b.WriteString("\n")
+	body, err := partText(msg.Header.Get, msg.Body)
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(body)
+	return b.String(), nil
+}
+
+// partText returns the text of a MIME entity: the plain text alternative
+// when there is one, otherwise HTML converted to markdown.
+func partText(header func(string) string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	body = transferDecoder(header("Content-Transfer-Encoding"), body)
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		if mediaType == "text/html" {
+			return HTMLToMarkdown(DecodeCharset(data, params["charset"])), nil
+		}
+		if strings.HasPrefix(mediaType, "text/") {
+			return DecodeCharset(data, params["charset"]), nil
+		}
+		return "", nil
+	}
+
+	var plain, htmlText string
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if strings.HasPrefix(part.Header.Get("Content-Disposition"), "attachment") {
+			continue
+		}
+		text, err := partText(part.Header.Get, part)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case partType == "text/html" && htmlText == "":
+			htmlText = text
+		case text != "" && plain == "":
+			plain = text
+		}
+	}
+	if plain != "" {
+		return plain, nil
+	}
+	return htmlText, nil
+}
+
+// DecodeCharset converts data from charset, as named in a Content-Type
+// header (e.g. ISO-8859-2, Windows-1251, Shift_JIS), to UTF-8. Data in an
+// unknown charset is read as UTF-8 with invalid bytes replaced.
+func DecodeCharset(data []byte, charset string) string {
+	charset = strings.ToLower(strings.Trim(strings.TrimSpace(charset), `"`))
+	if charset != "" && charset != "utf-8" && charset != "utf8" && charset != "us-ascii" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(data); err == nil {
+				return string(decoded)
+			}
+		}
+	}
+	if !utf8.Valid(data) {
+		return strings.ToValidUTF8(string(data), "\uFFFD")
+	}
+	return string(data)
+}
+
+// transferDecoder undoes a Content-Transfer-Encoding.
+func transferDecoder(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// newlineStripper drops CR and LF so line wrapped base64 can be decoded.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		count, err := n.r.Read(p)
+		kept := 0
+		for _, c := range p[:count] {
+			if c != '\r' && c != '\n' {
+				p[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// blockElements start on a new line when converted to text.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "tr": true, "li": true, "table": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "hr": true, "ul": true, "ol": true,
+}
+
+// rawTextElements hold raw text up to their end tag. Browsers ignore the
+// slash of <script/>, but mail written as XHTML uses it for empty elements.
+var rawTextElements = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+}
+
+// closeEmptyRawText follows self-closing raw text elements such as
+// <style/> with their end tag, so that parsing does not take the rest of
+// the document for their content.
+func closeEmptyRawText(doc string) string {
+	if !strings.Contains(doc, "/>") {
+		return doc
+	}
+	z := html.NewTokenizer(strings.NewReader(doc))
+	var b strings.Builder
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+		b.Write(z.Raw())
+		if tt == html.SelfClosingTagToken {
+			if name, _ := z.TagName(); rawTextElements[string(name)] {
+				z.NextIsNotRawText()
+				b.WriteString("</" + string(name) + ">")
+			}
+		}
+	}
+}
+
+// HTMLToText returns the visible text of an HTML document, one block
+// element per line.
+func HTMLToText(doc string) string {
+	z := html.NewTokenizer(strings.NewReader(doc))
+	var b strings.Builder
+	skip := 0
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			return collapseBlankLines(b.String())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if tt == html.SelfClosingTagToken && rawTextElements[string(name)] {
+				z.NextIsNotRawText()
+			}
+			switch tag := string(name); {
+			case tag == "script" || tag == "style" || tag == "head":
+				// <script/> has no content and no end tag to wait for.
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case blockElements[tag]:
+				b.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch tag := string(name); {
+			case tag == "script" || tag == "style" || tag == "head":
+				if skip > 0 {
+					skip--
+				}
+			case blockElements[tag]:
+				b.WriteString("\n")
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(collapseSpaces(string(z.Text())))
+			}
+		}
+	}
+}
+
+// collapseSpaces folds runs of white space into one space, keeping a
+// leading or trailing one so adjacent inline elements stay separated.
+func collapseSpaces(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		if text != "" {
+			return " "
+		}
+		return ""
+	}
+	out := strings.Join(fields, " ")
+	if unicode.IsSpace(rune(text[0])) {
+		out = " " + out
+	}
+	if unicode.IsSpace(rune(text[len(text)-1])) {
+		out += " "
+	}
+	return out
+}
+
+// collapseBlankLines trims every line and keeps at most one blank line
+// between paragraphs.
+func collapseBlankLines(s string) string {
+	var out []string
+	blank := true
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"paragraphs", "<p>Hello</p><p>World</p>", "Hello\n\nWorld"},
+		{"inline elements", "<p>Hello <b>big</b>\n   <i>world</i></p>", "Hello big world"},
+		{"line breaks", "one<br>two<br/>three", "one\ntwo\nthree"},
+		{"hidden content", "<html><head><title>T</title><style>p{}</style></head><body><script>alert(1)</script>Shown</body></html>", "Shown"},
+		{"self-closing script", "<p>before</p><script/><p>after</p>", "before\n\nafter"},
+		{"self-closing style", "<style/>Hello <b>there</b>", "Hello there"},
+		{"self-closing head", "<head/><p>Body text</p>", "Body text"},
+		{"entities", "<p>Fish &amp; chips &lt;3</p>", "Fish & chips <3"},
+	}
+	for _, tt := range tests {
+		if got := HTMLToText(tt.html); got != tt.want {
+			t.Errorf("%s: HTMLToText(%q) = %q, want %q", tt.name, tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"paragraphs", "<p>Hello</p><p>World</p>", "Hello\n\nWorld"},
+		{"heading", "<h2>Agenda</h2><p>Items</p>", "## Agenda\n\nItems"},
+		{"emphasis", "<p><b>bold</b> and <em>italic</em> and <code>x := 1</code></p>", "**bold** and _italic_ and `x := 1`"},
+		{"links", `<a href="https://example.com">site</a> <a href="mailto:a@example.com">a@example.com</a> <a href="#top">top</a>`,
+			"[site](https://example.com) <a@example.com> top"},
+		{"bullet list", "<ul><li>one</li><li>two</li></ul>", "- one\n- two"},
+		{"numbered list", "<ol><li>one</li><li>two</li></ol>", "1. one\n2. two"},
+		{"nested list", "<ul><li>one<ul><li>inner</li></ul></li></ul>", "- one\n  - inner"},
+		{"quote", "<p>Reply</p><blockquote><p>first</p><p>second</p></blockquote>", "Reply\n\n> first\n>\n> second"},
+		{"preformatted", "<pre>a  b\n  c</pre>", "```\na  b\n  c\n```"},
+		{"image", `<p><img src="cid:logo" alt="Logo"> text</p>`, "Logo text"},
+		{"layout table", "<table><tr><td>a</td><td>b</td></tr><tr><td>c</td></tr></table>", "a b\nc"},
+		{"hidden content", "<head><style>p{}</style></head><script>x</script><p>Shown</p>", "Shown"},
+		{"self-closing style", "<style/><p>Hello</p><p>World</p>", "Hello\n\nWorld"},
+		{"self-closing script", "<p>before</p><script/><p>after</p>", "before\n\nafter"},
+	}
+	for _, tt := range tests {
+		if got := HTMLToMarkdown(tt.html); got != tt.want {
+			t.Errorf("%s: HTMLToMarkdown(%q) = %q, want %q", tt.name, tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		charset string
+		want    string
+	}{
+		{"utf-8", []byte("Zoë"), "UTF-8", "Zoë"},
+		{"no charset", []byte("plain"), "", "plain"},
+		{"latin-1", []byte("Caf\xe9"), "ISO-8859-1", "Café"},
+		{"quoted name", []byte("Caf\xe9"), `"iso-8859-1"`, "Café"},
+		{"latin-2", []byte("\xb3\xf3d\xbc"), "ISO-8859-2", "łódź"},
+		{"windows-1251", []byte("\xcf\xf0\xe8\xe2\xe5\xf2"), "windows-1251", "Привет"},
+		{"shift_jis", []byte("\x93\xfa\x96\x7b"), "Shift_JIS", "日本"},
+		{"unknown charset", []byte("hello"), "x-unknown", "hello"},
+		{"invalid utf-8", []byte("ab\xffcd"), "utf-8", "ab�cd"},
+	}
+	for _, tt := range tests {
+		if got := DecodeCharset(tt.data, tt.charset); got != tt.want {
+			t.Errorf("%s: DecodeCharset = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	eml := "From: Alice <alice@example.com>\r\n" +
+		"Subject: =?UTF-8?Q?Caf=C3=A9?=\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=ISO-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=E9 ol=\r\ne\r\n" +
+		"--b--\r\n"
+	tests := []struct {
+		filename    string
+		contentType string
+		data        string
+		want        string
+	}{
+		{"notes.txt", "", "plain", "plain"},
+		{"page.html", "", "<p>Hello</p><style/><p>World</p>", "Hello\n\nWorld"},
+		{"x", "text/html; charset=ISO-8859-1", "<p>Caf\xe9</p>", "Café"},
+		{"data.json", "application/octet-stream", `{"a":1}`, "{\n  \"a\": 1\n}"},
+		{"mail.eml", "", eml, "From: Alice <alice@example.com>\nSubject: Café\n\nCafé ole"},
+	}
+	for _, tt := range tests {
+		got, err := ExtractText(tt.filename, tt.contentType, []byte(tt.data))
+		if err != nil || got != tt.want {
+			t.Errorf("ExtractText(%s, %q) = %q, %v; want %q", tt.filename, tt.contentType, got, err, tt.want)
+		}
+	}
+	if _, err := ExtractText("photo.png", "image/png", []byte("\x89PNG")); !errors.Is(err, ErrUnsupportedContent) {
+		t.Errorf("ExtractText of an image = %v, want ErrUnsupportedContent", err)
+	}
+}
@@ -3,88 +3,224 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/youtube/v3"
+	"golang.org/x/oauth2/jwt"
 )
 
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+// TokenFromFile retrieves a token from a local file.
+func TokenFromFile(file string) (*oauth2.Token, error) {
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s does not exist", ErrMissingToken, file)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-func ListChatScopes() []string {
-	return []string{
-		"https://www.googleapis.com/auth/chat.admin.memberships",
-		"https://www.googleapis.com/auth/chat.admin.memberships.readonly",
-		"https://www.googleapis.com/auth/chat.admin.spaces",
-		"https://www.googleapis.com/auth/chat.admin.spaces.readonly",
-		"https://www.googleapis.com/auth/chat.memberships",
-		"https://www.googleapis.com/auth/chat.memberships.app",
-		"https://www.googleapis.com/auth/chat.memberships.readonly",
-		"https://www.googleapis.com/auth/chat.messages",
-		"https://www.googleapis.com/auth/chat.messages.create",
-		"https://www.googleapis.com/auth/chat.messages.reactions",
-		"https://www.googleapis.com/auth/chat.messages.reactions.create",
-		"https://www.googleapis.com/auth/chat.messages.reactions.readonly",
-		"https://www.googleapis.com/auth/chat.messages.readonly",
-		"https://www.googleapis.com/auth/chat.spaces",
-		"https://www.googleapis.com/auth/chat.spaces.create",
-		"https://www.googleapis.com/auth/chat.spaces.readonly",
-		"https://www.googleapis.com/auth/chat.users.readstate",
-		"https://www.googleapis.com/auth/chat.users.readstate.readonly",
-	}
-}
-func ListGoogleScopes() []string {
-	scopes := []string{
-		gmail.GmailLabelsScope,
-		gmail.GmailModifyScope,
-		gmail.MailGoogleComScope,
-		gmail.GmailSettingsBasicScope,
-		calendar.CalendarScope,
-		calendar.CalendarEventsScope,
-		youtube.YoutubeScope,
-		youtube.YoutubeUploadScope,
-		youtube.YoutubepartnerChannelAuditScope,
-		youtube.YoutubepartnerScope,
-		youtube.YoutubeReadonlyScope,
-	}
-	scopes = append(scopes, ListChatScopes()...)
-	return scopes
-}
-
-func GoogleHttpClient(tokenFile string, credentialsFile string) *http.Client {
-
-	tok, err := tokenFromFile(tokenFile)
+	tok, err := DecodeToken(b)
 	if err != nil {
-		panic(fmt.Sprintf("failed to read token file: %v", err))
+		return nil, fmt.Errorf("%w: %s is not a valid token: %v", ErrMissingToken, file, err)
 	}
+	return tok, nil
+}
 
-	ctx := context.Background()
+// storedToken is the JSON form of a token. Unlike oauth2.Token it keeps the
+// granted scopes so tools can check them before calling Google.
+type storedToken struct {
+	*oauth2.Token
+	Scope string `json:"scope,omitempty"`
+}
+
+// EncodeToken serializes tok including its granted scopes.
+func EncodeToken(tok *oauth2.Token) ([]byte, error) {
+	return json.Marshal(storedToken{Token: tok, Scope: strings.Join(TokenScopes(tok), " ")})
+}
+
+// DecodeToken parses a token written by EncodeToken or a plain oauth2.Token.
+func DecodeToken(b []byte) (*oauth2.Token, error) {
+	stored := storedToken{Token: &oauth2.Token{}}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Scope == "" {
+		return stored.Token, nil
+	}
+	return stored.Token.WithExtra(map[string]interface{}{"scope": stored.Scope}), nil
+}
+
+// GoogleConfig reads the OAuth client from credentialsFile. Scopes only
+// matter for new consent; they default to DefaultScopes.
+func GoogleConfig(credentialsFile string, scopes ...string) (*oauth2.Config, error) {
 	b, err := os.ReadFile(credentialsFile)
 	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+		return nil, fmt.Errorf("%w: unable to read client secret file: %v", ErrMissingCredentials, err)
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, ListGoogleScopes()...)
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	config, err := google.ConfigFromJSON(b, scopes...)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return nil, fmt.Errorf("%w: unable to parse client secret file to config: %v", ErrMissingCredentials, err)
+	}
+	return config, nil
+}
+
+// GoogleHttpClient returns an HTTP client authorized with the token stored in
+// tokenFile, saving refreshed tokens back to it. Credential problems are
+// reported as the errors in errors.go.
+func GoogleHttpClient(tokenFile string, credentialsFile string) (*http.Client, error) {
+	return GoogleStoreClient(FileTokenStore{Path: tokenFile}, credentialsFile)
+}
+
+// Delegation configures a Workspace service account with domain-wide
+// delegation, which can act as any user it is allowed to without their own
+// consent.
+type Delegation struct {
+	// KeyFile is the service account JSON key.
+	KeyFile string
+	// Allowed lists the users that may be impersonated: full addresses,
+	// or "@domain" for every user of a domain.
+	Allowed []string
+}
+
+// DelegationFromEnv reads GOOGLE_SERVICE_ACCOUNT_FILE and the comma separated
+// GOOGLE_DELEGATION_ALLOW.
+func DelegationFromEnv() Delegation {
+	d := Delegation{KeyFile: os.Getenv("GOOGLE_SERVICE_ACCOUNT_FILE")}
+	for _, entry := range strings.Split(os.Getenv("GOOGLE_DELEGATION_ALLOW"), ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			d.Allowed = append(d.Allowed, entry)
+		}
 	}
+	return d
+}
+
+// Enabled reports whether a service account key is configured.
+func (d Delegation) Enabled() bool {
+	return d.KeyFile != ""
+}
+
+// Allows reports whether subject may be impersonated. Nobody is allowed
+// when the allow list is empty.
+func (d Delegation) Allows(subject string) bool {
+	subject = strings.ToLower(subject)
+	_, domain, ok := strings.Cut(subject, "@")
+	if !ok || domain == "" {
+		return false
+	}
+	for _, entry := range d.Allowed {
+		if entry == subject || entry == "@"+domain {
+			return true
+		}
+	}
+	return false
+}
 
-	return config.Client(ctx, tok)
+// Config returns the JWT config that impersonates subject. Scopes default
+// to DefaultScopes and must be authorized for the service account in the
+// Workspace admin console.
+func (d Delegation) Config(subject string, scopes ...string) (*jwt.Config, error) {
+	if !d.Enabled() {
+		return nil, fmt.Errorf("%w: GOOGLE_SERVICE_ACCOUNT_FILE is not set", ErrMissingCredentials)
+	}
+	if !d.Allows(subject) {
+		return nil, fmt.Errorf("%w: %s is not in GOOGLE_DELEGATION_ALLOW", ErrDelegationDenied, subject)
+	}
+	b, err := os.ReadFile(d.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to read service account key: %v", ErrMissingCredentials, err)
+	}
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	config, err := google.JWTConfigFromJSON(b, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to parse service account key: %v", ErrMissingCredentials, err)
+	}
+	config.Subject = subject
+	return config, nil
+}
+
+// Client returns an HTTP client acting as subject.
+func (d Delegation) Client(subject string) (*http.Client, error) {
+	config, err := d.Config(subject)
+	if err != nil {
+		return nil, err
+	}
+	src := classifyingTokenSource{config.TokenSource(context.Background())}
+	return oauth2.NewClient(context.Background(), src), nil
+}
+
+// classifyingTokenSource reports token failures as the errors in errors.go.
+type classifyingTokenSource struct {
+	src oauth2.TokenSource
+}
+
+func (c classifyingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := c.src.Token()
+	return tok, ClassifyGoogleError(err)
+}
+
+// TokenInfo describes the grant behind a token as reported by Google.
+type TokenInfo struct {
+	Email           string
+	Scopes          []string
+	Expiry          time.Time
+	HasRefreshToken bool
+}
+
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// GoogleTokenInfo refreshes the token in store if needed and asks Google
+// which account and scopes it carries.
+func GoogleTokenInfo(ctx context.Context, store TokenStore, credentialsFile string) (*TokenInfo, error) {
+	tok, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	config, err := GoogleConfig(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := PersistingTokenSource(config.TokenSource(ctx, tok), store, tok).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		tokenInfoURL+"?access_token="+url.QueryEscape(fresh.AccessToken), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: tokeninfo returned %s", ErrTokenRevoked, resp.Status)
+	}
+
+	var body struct {
+		Email string `json:"email"`
+		Scope string `json:"scope"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to decode tokeninfo response: %w", err)
+	}
+	return &TokenInfo{
+		Email:           body.Email,
+		Scopes:          strings.Fields(body.Scope),
+		Expiry:          fresh.Expiry,
+		HasRefreshToken: fresh.RefreshToken != "",
+	}, nil
 }
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blankLines matches runs of blank lines left by nested block elements;
+// quoteSeparator the blank lines of a quote.
+var (
+	blankLines     = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+\n`)
+	quoteSeparator = regexp.MustCompile(`^>[> ]*$`)
+)
+
+// HTMLToMarkdown converts an HTML e-mail body to markdown: headings,
+// paragraphs, emphasis, links, lists, quotes and preformatted text keep
+// their meaning, layout tables become plain lines and images are reduced
+// to their alt text. Invalid HTML falls back to HTMLToText.
+func HTMLToMarkdown(doc string) string {
+	root, err := html.Parse(strings.NewReader(closeEmptyRawText(doc)))
+	if err != nil {
+		return HTMLToText(doc)
+	}
+	w := &markdownWriter{lineStart: true}
+	w.children(root)
+
+	lines := strings.Split(w.b.String(), "\n")
+	var kept []string
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		// A bare ">" only separates paragraphs inside a quote.
+		if quoteSeparator.MatchString(line) && (len(kept) == 0 || !strings.HasPrefix(kept[len(kept)-1], ">") ||
+			quoteSeparator.MatchString(kept[len(kept)-1]) || i+1 == len(lines) || !strings.HasPrefix(lines[i+1], ">")) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	out := blankLines.ReplaceAllString(strings.Join(kept, "\n"), "\n\n")
+	return strings.TrimSpace(out)
+}
+
+// markdownWriter renders nodes to markdown. prefix is written at the start
+// of every line, for quotes and list items.
+type markdownWriter struct {
+	b         strings.Builder
+	prefix    string
+	lineStart bool
+	list      []int // per open list: -1 for bullets, else the next number
+	pre       bool
+}
+
+func (w *markdownWriter) write(s string) {
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			w.b.WriteString("\n")
+			w.lineStart = true
+		}
+		if line == "" {
+			continue
+		}
+		if w.lineStart {
+			w.b.WriteString(w.prefix)
+			w.lineStart = false
+		}
+		w.b.WriteString(line)
+	}
+}
+
+func (w *markdownWriter) newline() {
+	if !w.lineStart {
+		w.b.WriteString("\n")
+		w.lineStart = true
+	}
+}
+
+func (w *markdownWriter) blankLine() {
+	w.newline()
+	w.b.WriteString(strings.TrimRight(w.prefix, " ") + "\n")
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// inline renders the children of n on their own and returns them as a
+// single line, for wrapping in emphasis or link syntax.
+func (w *markdownWriter) inline(n *html.Node) string {
+	sub := &markdownWriter{lineStart: true}
+	sub.children(n)
+	return strings.Join(strings.Fields(sub.b.String()), " ")
+}
+
+func (w *markdownWriter) text(s string) {
+	if w.pre {
+		w.write(s)
+		return
+	}
+	s = collapseSpaces(s)
+	if w.lineStart || strings.HasSuffix(w.b.String(), " ") {
+		s = strings.TrimLeft(s, " ")
+	}
+	if s != "" {
+		w.write(s)
+	}
+}
+
+func (w *markdownWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch tag := n.Data; tag {
+	case "head", "script", "style", "title", "noscript", "template":
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		if text := w.inline(n); text != "" {
+			w.blankLine()
+			w.write(strings.Repeat("#", int(tag[1]-'0')) + " " + text)
+			w.blankLine()
+		}
+	case "p":
+		w.blankLine()
+		w.children(n)
+		w.blankLine()
+	case "div", "section", "article", "header", "footer", "tr", "center", "dd", "dt":
+		w.newline()
+		w.children(n)
+		w.newline()
+	case "td", "th":
+		w.children(n)
+		w.text(" ")
+	case "br":
+		w.newline()
+	case "hr":
+		w.blankLine()
+		w.write("---")
+		w.blankLine()
+	case "strong", "b":
+		w.wrap(n, "**")
+	case "em", "i":
+		w.wrap(n, "_")
+	case "code":
+		if w.pre {
+			w.children(n)
+		} else {
+			w.wrap(n, "`")
+		}
+	case "a":
+		href := strings.TrimSpace(attr(n, "href"))
+		text := w.inline(n)
+		switch {
+		case text == "":
+		case href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:"):
+			w.text(text)
+		case text == href || "mailto:"+text == href:
+			w.text("<" + text + ">")
+		default:
+			w.text(fmt.Sprintf("[%s](%s)", text, href))
+		}
+	case "img":
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			w.text(alt)
+		}
+	case "ul", "ol":
+		next := -1
+		if tag == "ol" {
+			next = 1
+		}
+		w.list = append(w.list, next)
+		w.newline()
+		w.children(n)
+		w.list = w.list[:len(w.list)-1]
+		w.newline()
+	case "li":
+		marker := "- "
+		if len(w.list) > 0 && w.list[len(w.list)-1] > 0 {
+			marker = fmt.Sprintf("%d. ", w.list[len(w.list)-1])
+			w.list[len(w.list)-1]++
+		}
+		// Nested lists are indented by the prefix of the enclosing item.
+		w.newline()
+		w.write(marker)
+		saved := w.prefix
+		w.prefix += strings.Repeat(" ", len(marker))
+		w.children(n)
+		w.prefix = saved
+		w.newline()
+	case "blockquote":
+		w.blankLine()
+		saved := w.prefix
+		w.prefix += "> "
+		w.children(n)
+		w.newline()
+		w.prefix = saved
+		w.blankLine()
+	case "pre":
+		w.blankLine()
+		w.write("```\n")
+		w.pre = true
+		w.children(n)
+		w.pre = false
+		w.newline()
+		w.write("```")
+		w.blankLine()
+	default:
+		w.children(n)
+	}
+}
+
+// wrap writes the children of n between marker, unless they are empty.
+func (w *markdownWriter) wrap(n *html.Node, marker string) {
+	if text := w.inline(n); text != "" {
+		w.write(marker + text + marker)
+	}
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedQuery is returned for search syntax the mail store cannot
+// evaluate, such as OR and grouping; such queries must go to Gmail.
+var ErrUnsupportedQuery = errors.New("query not supported offline")
+
+// MailQuery is the part of Gmail's search syntax the mail store answers:
+// words, quoted phrases, negation, from:, to:, cc:, subject:, label:, in:,
+// is:, has:attachment, after:, before: and newer_than:/older_than:.
+type MailQuery struct {
+	Words   []string
+	Phrases []string
+	// Excluded holds negated words and phrases, as term lists.
+	Excluded [][]string
+	From     []string
+	To       []string
+	Cc       []string
+	Subject  []string
+	// Labels and NotLabels hold label IDs or label names as used in
+	// queries, in lower case.
+	Labels        []string
+	NotLabels     []string
+	HasAttachment bool
+	After         time.Time
+	Before        time.Time
+}
+
+// systemLabels maps in: and is: values to label IDs.
+var systemLabels = map[string]string{
+	"inbox":     "INBOX",
+	"sent":      "SENT",
+	"draft":     "DRAFT",
+	"drafts":    "DRAFT",
+	"spam":      "SPAM",
+	"trash":     "TRASH",
+	"starred":   "STARRED",
+	"important": "IMPORTANT",
+	"unread":    "UNREAD",
+	"chats":     "CHAT",
+}
+
+// ParseMailQuery parses query, failing with ErrUnsupportedQuery for syntax
+// outside MailQuery.
+func ParseMailQuery(query string) (MailQuery, error) {
+	var q MailQuery
+	tokens, err := splitQuery(query)
+	if err != nil {
+		return q, err
+	}
+	now := time.Now()
+	for _, token := range tokens {
+		negated := strings.HasPrefix(token, "-") && len(token) > 1
+		if negated {
+			token = token[1:]
+		}
+		if token == "OR" || token == "AND" || strings.ContainsAny(token, "(){}|~") {
+			return q, fmt.Errorf("%w: %q", ErrUnsupportedQuery, token)
+		}
+
+		if strings.HasPrefix(token, `"`) {
+			terms := Terms(token)
+			if negated {
+				q.Excluded = append(q.Excluded, terms)
+				continue
+			}
+			q.Words = append(q.Words, terms...)
+			if len(terms) > 1 {
+				q.Phrases = append(q.Phrases, strings.Join(terms, " "))
+			}
+			continue
+		}
+
+		op, value, isOp := strings.Cut(token, ":")
+		if !isOp {
+			terms := Terms(token)
+			if negated {
+				q.Excluded = append(q.Excluded, terms)
+			} else {
+				q.Words = append(q.Words, terms...)
+			}
+			continue
+		}
+		value = strings.ToLower(strings.Trim(value, `"`))
+		if value == "" {
+			return q, fmt.Errorf("%w: %q", ErrUnsupportedQuery, token)
+		}
+
+		op = strings.ToLower(op)
+		if negated && op != "label" && op != "in" && op != "is" {
+			return q, fmt.Errorf("%w: %q", ErrUnsupportedQuery, "-"+token)
+		}
+		switch op {
+		case "from":
+			q.From = append(q.From, value)
+		case "to":
+			q.To = append(q.To, value)
+		case "cc":
+			q.Cc = append(q.Cc, value)
+		case "subject":
+			q.Subject = append(q.Subject, value)
+		case "label", "in", "is":
+			label := value
+			if op != "label" {
+				id, ok := systemLabels[value]
+				switch {
+				case ok:
+					label = strings.ToLower(id)
+				case op == "is" && value == "read":
+					label, negated = "unread", !negated
+				default:
+					return q, fmt.Errorf("%w: %q", ErrUnsupportedQuery, token)
+				}
+			}
+			if negated {
+				q.NotLabels = append(q.NotLabels, label)
+			} else {
+				q.Labels = append(q.Labels, label)
+			}
+		case "has":
+			if value != "attachment" {
+				return q, fmt.Errorf("%w: %q", ErrUnsupportedQuery, token)
+			}
+			q.HasAttachment = true
+		case "after", "before", "newer", "older":
+			at, err := parseQueryDate(value)
+			if err != nil {
+				return q, err
+			}
+			if op == "after" || op == "newer" {
+				q.After = at
+			} else {
+				q.Before = at
+			}
+		case "newer_than", "older_than":
+			at, err := relativeDate(now, value)
+			if err != nil {
+				return q, err
+			}
+			if op == "newer_than" {
+				q.After = at
+			} else {
+				q.Before = at
+			}
+		default:
+			return q, fmt.Errorf("%w: %q", ErrUnsupportedQuery, token)
+		}
+	}
+	return q, nil
+}
+
+// splitQuery splits query at spaces outside quotes, keeping the quotes.
+func splitQuery(query string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case r == ' ' && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%w: unbalanced quotes", ErrUnsupportedQuery)
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+// parseQueryDate parses dates as Gmail writes them, YYYY/MM/DD, in local
+// time, and Unix timestamps in seconds.
+func parseQueryDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006/1/2", "2006-1-2"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: date %q", ErrUnsupportedQuery, value)
+}
+
+// relativeDate resolves newer_than: and older_than: values such as 2d, 3m
+// and 1y.
+func relativeDate(now time.Time, value string) (time.Time, error) {
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("%w: period %q", ErrUnsupportedQuery, value)
+	}
+	switch value[len(value)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: period %q", ErrUnsupportedQuery, value)
+}
+
+// matches reports whether m satisfies every condition of q except the
+// words, which the index has already checked. labelNames maps label IDs to
+// names. Like Gmail, spam and trash only match when asked for.
+func (q MailQuery) matches(m *CachedMessage, labelNames map[string]string) bool {
+	hasLabel := func(want string) bool {
+		for _, id := range m.Labels {
+			name := strings.ToLower(labelNames[id])
+			if strings.ToLower(id) == want || name == want || strings.NewReplacer(" ", "-", "/", "-").Replace(name) == want {
+				return true
+			}
+		}
+		return false
+	}
+	for _, label := range q.Labels {
+		if !hasLabel(label) {
+			return false
+		}
+	}
+	for _, label := range q.NotLabels {
+		if hasLabel(label) {
+			return false
+		}
+	}
+	for _, hidden := range []string{"spam", "trash"} {
+		if hasLabel(hidden) && !slices.Contains(q.Labels, hidden) {
+			return false
+		}
+	}
+
+	for _, field := range []struct {
+		values []string
+		header string
+	}{{q.From, m.From}, {q.To, m.To}, {q.Cc, m.Cc}, {q.Subject, m.Subject}} {
+		header := strings.ToLower(field.header)
+		for _, v := range field.values {
+			if !strings.Contains(header, v) {
+				return false
+			}
+		}
+	}
+	if q.HasAttachment && !m.HasAttachment {
+		return false
+	}
+	at := time.UnixMilli(m.InternalDate)
+	if !q.After.IsZero() && at.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !at.Before(q.Before) {
+		return false
+	}
+
+	if len(q.Phrases) == 0 && len(q.Excluded) == 0 {
+		return true
+	}
+	text := " " + strings.Join(Terms(searchText(m)), " ") + " "
+	for _, phrase := range q.Phrases {
+		if !strings.Contains(text, " "+phrase+" ") {
+			return false
+		}
+	}
+	for _, terms := range q.Excluded {
+		if len(terms) > 0 && strings.Contains(text, " "+strings.Join(terms, " ")+" ") {
+			return false
+		}
+	}
+	return true
+}
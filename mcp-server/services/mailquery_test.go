@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMailQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  MailQuery
+	}{
+		{"", MailQuery{}},
+		{"Quarterly Report", MailQuery{Words: []string{"quarterly", "report"}}},
+		{`"quarterly report"`, MailQuery{Words: []string{"quarterly", "report"}, Phrases: []string{"quarterly report"}}},
+		{`"budget"`, MailQuery{Words: []string{"budget"}}},
+		{`invoice -draft -"do not reply"`, MailQuery{
+			Words:    []string{"invoice"},
+			Excluded: [][]string{{"draft"}, {"do", "not", "reply"}},
+		}},
+		{"from:Alice@Example.com to:bob cc:carol subject:Lunch", MailQuery{
+			From:    []string{"alice@example.com"},
+			To:      []string{"bob"},
+			Cc:      []string{"carol"},
+			Subject: []string{"lunch"},
+		}},
+		{`subject:"weekly sync"`, MailQuery{Subject: []string{"weekly sync"}}},
+		{"in:inbox is:unread label:Clients/Acme -label:done", MailQuery{
+			Labels:    []string{"inbox", "unread", "clients/acme"},
+			NotLabels: []string{"done"},
+		}},
+		{"is:read -is:starred", MailQuery{NotLabels: []string{"unread", "starred"}}},
+		{"-is:read", MailQuery{Labels: []string{"unread"}}},
+		{"has:attachment", MailQuery{HasAttachment: true}},
+		{"after:2024/01/15 before:2024-02-01", MailQuery{
+			After:  time.Date(2024, 1, 15, 0, 0, 0, 0, time.Local),
+			Before: time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local),
+		}},
+		{"after:1700000000", MailQuery{After: time.Unix(1700000000, 0)}},
+	}
+	for _, tt := range tests {
+		got, err := ParseMailQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseMailQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMailQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseMailQueryRelativeDates(t *testing.T) {
+	before := time.Now()
+	q, err := ParseMailQuery("newer_than:2d older_than:1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+	if q.After.Before(before.AddDate(0, 0, -2)) || q.After.After(after.AddDate(0, 0, -2)) {
+		t.Errorf("newer_than:2d gave After = %v", q.After)
+	}
+	if q.Before.Before(before.AddDate(0, -1, 0)) || q.Before.After(after.AddDate(0, -1, 0)) {
+		t.Errorf("older_than:1m gave Before = %v", q.Before)
+	}
+}
+
+func TestParseMailQueryUnsupported(t *testing.T) {
+	for _, query := range []string{
+		"apples OR pears",
+		"apples AND pears",
+		"{apples pears}",
+		"(apples pears)",
+		"-from:alice",
+		"in:anywhere",
+		"is:muted",
+		"has:drive",
+		"size:10M",
+		"from:",
+		"after:yesterday",
+		"newer_than:2w",
+		`"unbalanced`,
+	} {
+		if _, err := ParseMailQuery(query); !errors.Is(err, ErrUnsupportedQuery) {
+			t.Errorf("ParseMailQuery(%q) error = %v, want ErrUnsupportedQuery", query, err)
+		}
+	}
+}
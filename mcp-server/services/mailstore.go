@@ -0,0 +1,428 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+)
+
+// validMessageID guards file names built from message IDs.
+var validMessageID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CachedMessage is what the mail store keeps of one message.
+type CachedMessage struct {
+	ID            string   `json:"id"`
+	ThreadID      string   `json:"thread_id"`
+	From          string   `json:"from"`
+	To            string   `json:"to,omitempty"`
+	Cc            string   `json:"cc,omitempty"`
+	Subject       string   `json:"subject"`
+	Date          string   `json:"date"`
+	Snippet       string   `json:"snippet"`
+	Body          string   `json:"body"`
+	Labels        []string `json:"labels"`
+	InternalDate  int64    `json:"internal_date"`
+	HasAttachment bool     `json:"has_attachment,omitempty"`
+	Size          int64    `json:"size"`
+}
+
+// MailStoreState records how far the store is synced.
+type MailStoreState struct {
+	HistoryID uint64    `json:"history_id"`
+	LastSync  time.Time `json:"last_sync"`
+	// Labels maps label IDs to names, for label: queries.
+	Labels map[string]string `json:"labels"`
+	// Truncated is set when the fill stopped at its message limit. The
+	// store then holds the mailbox only from Since, its oldest message, on.
+	Truncated bool      `json:"truncated,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+}
+
+// Covers reports whether the store holds every message q can match, so
+// that its answer is the mailbox's.
+func (s MailStoreState) Covers(q MailQuery) bool {
+	return !s.Truncated || (!q.After.IsZero() && !q.After.Before(s.Since))
+}
+
+// MailStoreStats describes the contents of a store.
+type MailStoreStats struct {
+	Messages  int
+	Terms     int
+	DiskBytes int64
+	Oldest    time.Time
+	Newest    time.Time
+	State     MailStoreState
+}
+
+// MailStore keeps messages on disk, one JSON file each, with an in-memory
+// full-text index that is rebuilt when the store is opened. With a keyring
+// every file is sealed with it.
+type MailStore struct {
+	mu       sync.RWMutex
+	dir      string
+	keys     *session.Keyring
+	state    MailStoreState
+	messages map[string]*CachedMessage
+	// index maps each term to the IDs of the messages containing it.
+	index map[string]map[string]struct{}
+}
+
+// OpenMailStore opens or creates the store in dir, encrypted with keys
+// unless keys is nil. Message files that fail to parse are logged and left
+// out. A store whose state cannot be read, for instance because it was
+// written with other keys, is emptied so the next sync fills it again.
+func OpenMailStore(dir string, keys *session.Keyring) (*MailStore, error) {
+	s := &MailStore{dir: dir, keys: keys}
+	s.reset()
+	if err := os.MkdirAll(s.messageDir(), 0700); err != nil {
+		return nil, fmt.Errorf("unable to create mail store: %w", err)
+	}
+
+	err := s.readFile(s.statePath(), &s.state)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		log.Printf("Emptying unreadable mail store %s: %v", dir, err)
+		if err := os.RemoveAll(s.messageDir()); err != nil {
+			return nil, fmt.Errorf("unable to purge mail store: %w", err)
+		}
+		s.reset()
+		if err := os.MkdirAll(s.messageDir(), 0700); err != nil {
+			return nil, fmt.Errorf("unable to create mail store: %w", err)
+		}
+	}
+
+	entries, err := os.ReadDir(s.messageDir())
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mail store: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		var m CachedMessage
+		if err := s.readFile(filepath.Join(s.messageDir(), entry.Name()), &m); err != nil || m.ID == "" {
+			log.Printf("Skipping unreadable cached message %s: %v", entry.Name(), err)
+			continue
+		}
+		s.add(&m)
+	}
+	return s, nil
+}
+
+func (s *MailStore) reset() {
+	s.state = MailStoreState{}
+	s.messages = make(map[string]*CachedMessage)
+	s.index = make(map[string]map[string]struct{})
+}
+
+func (s *MailStore) messageDir() string {
+	return filepath.Join(s.dir, "messages")
+}
+
+func (s *MailStore) statePath() string {
+	return filepath.Join(s.dir, "state.json")
+}
+
+func (s *MailStore) messagePath(id string) string {
+	return filepath.Join(s.messageDir(), id+".json")
+}
+
+// State returns how far the store is synced.
+func (s *MailStore) State() MailStoreState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// SetState records a completed sync.
+func (s *MailStore) SetState(state MailStoreState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writeFileAtomic(s.statePath(), state); err != nil {
+		return err
+	}
+	s.state = state
+	return nil
+}
+
+// Put adds messages or replaces the stored copies.
+func (s *MailStore) Put(messages ...CachedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range messages {
+		m := &messages[i]
+		if !validMessageID.MatchString(m.ID) {
+			return fmt.Errorf("invalid message ID %q", m.ID)
+		}
+		if err := s.writeFileAtomic(s.messagePath(m.ID), m); err != nil {
+			return err
+		}
+		s.remove(m.ID)
+		s.add(m)
+	}
+	return nil
+}
+
+// Delete drops messages; unknown IDs are ignored.
+func (s *MailStore) Delete(ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := s.messages[id]; !ok {
+			continue
+		}
+		if err := os.Remove(s.messagePath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to delete cached message: %w", err)
+		}
+		s.remove(id)
+	}
+	return nil
+}
+
+// Relabel changes the labels of a stored message. It reports false when the
+// message is not stored.
+func (s *MailStore) Relabel(id string, add, remove []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.messages[id]
+	if !ok {
+		return false, nil
+	}
+	m := *stored
+	var labels []string
+	for _, label := range m.Labels {
+		if !slices.Contains(remove, label) && !slices.Contains(add, label) {
+			labels = append(labels, label)
+		}
+	}
+	m.Labels = append(labels, add...)
+	if err := s.writeFileAtomic(s.messagePath(id), m); err != nil {
+		return true, err
+	}
+	// Labels are not indexed, so the index stays as it is.
+	s.messages[id] = &m
+	return true, nil
+}
+
+// Purge deletes every stored message and the sync state.
+func (s *MailStore) Purge() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("unable to purge mail store: %w", err)
+	}
+	s.reset()
+	if err := os.MkdirAll(s.messageDir(), 0700); err != nil {
+		return fmt.Errorf("unable to create mail store: %w", err)
+	}
+	return nil
+}
+
+// Stats describes the contents of the store.
+func (s *MailStore) Stats() (MailStoreStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := MailStoreStats{Messages: len(s.messages), Terms: len(s.index), State: s.state}
+	for _, m := range s.messages {
+		at := time.UnixMilli(m.InternalDate)
+		if stats.Oldest.IsZero() || at.Before(stats.Oldest) {
+			stats.Oldest = at
+		}
+		if at.After(stats.Newest) {
+			stats.Newest = at
+		}
+	}
+	err := filepath.WalkDir(s.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stats.DiskBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("unable to measure mail store: %w", err)
+	}
+	return stats, nil
+}
+
+// Search returns the messages matching q, newest first, skipping the first
+// offset and returning at most limit, together with the number of matches.
+func (s *MailStore) Search(q MailQuery, offset, limit int) ([]CachedMessage, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Candidates are the messages containing every word; without words,
+	// every message.
+	var candidates map[string]struct{}
+	for _, word := range q.Words {
+		ids := s.index[word]
+		if candidates == nil {
+			candidates = make(map[string]struct{}, len(ids))
+			for id := range ids {
+				candidates[id] = struct{}{}
+			}
+			continue
+		}
+		for id := range candidates {
+			if _, ok := ids[id]; !ok {
+				delete(candidates, id)
+			}
+		}
+	}
+
+	var matches []*CachedMessage
+	consider := func(m *CachedMessage) {
+		if q.matches(m, s.state.Labels) {
+			matches = append(matches, m)
+		}
+	}
+	if len(q.Words) == 0 {
+		for _, m := range s.messages {
+			consider(m)
+		}
+	} else {
+		for id := range candidates {
+			consider(s.messages[id])
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].InternalDate != matches[j].InternalDate {
+			return matches[i].InternalDate > matches[j].InternalDate
+		}
+		return matches[i].ID > matches[j].ID
+	})
+
+	var page []CachedMessage
+	for i := offset; i < len(matches) && len(page) < limit; i++ {
+		page = append(page, *matches[i])
+	}
+	return page, len(matches)
+}
+
+func (s *MailStore) add(m *CachedMessage) {
+	s.messages[m.ID] = m
+	for _, term := range uniqueTerms(m) {
+		ids := s.index[term]
+		if ids == nil {
+			ids = make(map[string]struct{})
+			s.index[term] = ids
+		}
+		ids[m.ID] = struct{}{}
+	}
+}
+
+func (s *MailStore) remove(id string) {
+	m, ok := s.messages[id]
+	if !ok {
+		return
+	}
+	for _, term := range uniqueTerms(m) {
+		delete(s.index[term], id)
+		if len(s.index[term]) == 0 {
+			delete(s.index, term)
+		}
+	}
+	delete(s.messages, id)
+}
+
+// searchText is the text of a message that words and phrases match.
+func searchText(m *CachedMessage) string {
+	return strings.Join([]string{m.From, m.To, m.Cc, m.Subject, m.Snippet, m.Body}, "\n")
+}
+
+func uniqueTerms(m *CachedMessage) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range Terms(searchText(m)) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Terms splits text into lower case words of letters and digits, the unit
+// of the full-text index.
+func Terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fileAAD binds a sealed file to its place in the store, so that files
+// cannot be swapped for one another.
+func (s *MailStore) fileAAD(path string) []byte {
+	rel, err := filepath.Rel(s.dir, path)
+	if err != nil {
+		rel = path
+	}
+	return []byte(filepath.ToSlash(rel))
+}
+
+// readFile reads the JSON file at path into v, opening it first when the
+// store is encrypted.
+func (s *MailStore) readFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if s.keys != nil {
+		var sealed session.Sealed
+		if err := json.Unmarshal(b, &sealed); err != nil {
+			return err
+		}
+		if b, err = s.keys.Open(sealed, s.fileAAD(path)); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeFileAtomic writes v as JSON, sealed when the store is encrypted, to
+// a temporary file and renames it over path.
+func (s *MailStore) writeFileAtomic(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.keys != nil {
+		sealed, err := s.keys.Seal(b, s.fileAAD(path))
+		if err != nil {
+			return err
+		}
+		if b, err = json.Marshal(sealed); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".mail-store-*")
+	if err != nil {
+		return fmt.Errorf("unable to write mail store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write mail store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
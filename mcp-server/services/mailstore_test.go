@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+)
+
+func testKeyring(t *testing.T) *session.Keyring {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := session.ParseKeyring(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func day(d int) int64 {
+	return time.Date(2024, 3, d, 12, 0, 0, 0, time.Local).UnixMilli()
+}
+
+var testMessages = []CachedMessage{
+	{
+		ID: "m1", From: "Alice <alice@example.com>", To: "me@example.com", Subject: "Quarterly report",
+		Body: "The quarterly report is attached.", Labels: []string{"INBOX", "UNREAD"},
+		InternalDate: day(1), HasAttachment: true,
+	},
+	{
+		ID: "m2", From: "Bob <bob@example.com>", To: "me@example.com", Cc: "alice@example.com", Subject: "Lunch",
+		Body: "Report back on lunch plans, please.", Labels: []string{"INBOX", "Label_1"},
+		InternalDate: day(5),
+	},
+	{
+		ID: "m3", From: "Alice <alice@example.com>", To: "bob@example.com", Subject: "Re: Lunch",
+		Body: "Lunch works. Do not reply to the report thread.", Labels: []string{"SENT"},
+		InternalDate: day(10),
+	},
+	{
+		ID: "m4", From: "Spammer <win@spam.example>", Subject: "Quarterly prize",
+		Body: "You won a quarterly report prize", Labels: []string{"SPAM"},
+		InternalDate: day(12),
+	},
+}
+
+func newTestStore(t *testing.T, keys *session.Keyring) *MailStore {
+	t.Helper()
+	store, err := OpenMailStore(t.TempDir(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(testMessages...); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetState(MailStoreState{HistoryID: 7, Labels: map[string]string{"Label_1": "Clients/Acme"}}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestMailStoreSearch(t *testing.T) {
+	store := newTestStore(t, nil)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"m3", "m2", "m1"}},
+		{"report", []string{"m3", "m2", "m1"}},
+		{`"quarterly report"`, []string{"m1"}},
+		{"lunch -\"do not reply\"", []string{"m2"}},
+		{"from:alice", []string{"m3", "m1"}},
+		{"to:bob", []string{"m3"}},
+		{"cc:alice", []string{"m2"}},
+		{"subject:lunch", []string{"m3", "m2"}},
+		{"in:inbox is:unread", []string{"m1"}},
+		{"is:read in:inbox", []string{"m2"}},
+		{"label:clients/acme", []string{"m2"}},
+		{"label:clients-acme", []string{"m2"}},
+		{"-in:inbox", []string{"m3"}},
+		{"has:attachment", []string{"m1"}},
+		{"after:2024/03/05 before:2024/03/11", []string{"m3", "m2"}},
+		{"in:spam quarterly", []string{"m4"}},
+		{"nothing-matches", nil},
+	}
+	for _, tt := range tests {
+		q, err := ParseMailQuery(tt.query)
+		if err != nil {
+			t.Fatalf("ParseMailQuery(%q): %v", tt.query, err)
+		}
+		page, total := store.Search(q, 0, 10)
+		var got []string
+		for _, m := range page {
+			got = append(got, m.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) || total != len(tt.want) {
+			t.Errorf("Search(%q) = %v (%d in all), want %v", tt.query, got, total, tt.want)
+		}
+	}
+}
+
+func TestMailStoreSearchPages(t *testing.T) {
+	store := newTestStore(t, nil)
+	q, _ := ParseMailQuery("")
+	page, total := store.Search(q, 1, 1)
+	if len(page) != 1 || page[0].ID != "m2" || total != 3 {
+		t.Errorf("second page = %v of %d, want m2 of 3", page, total)
+	}
+	if page, _ := store.Search(q, 3, 1); len(page) != 0 {
+		t.Errorf("page past the end = %v, want nothing", page)
+	}
+}
+
+func TestMailStoreChanges(t *testing.T) {
+	store := newTestStore(t, nil)
+	if stored, err := store.Relabel("m3", []string{"INBOX"}, []string{"SENT"}); err != nil || !stored {
+		t.Fatalf("Relabel = %v, %v", stored, err)
+	}
+	if stored, _ := store.Relabel("unknown", []string{"INBOX"}, nil); stored {
+		t.Error("Relabel of an unknown message reported it stored")
+	}
+	if err := store.Delete("m1", "unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenMailStore(store.dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := ParseMailQuery("in:inbox")
+	page, _ := reopened.Search(q, 0, 10)
+	if len(page) != 2 || page[0].ID != "m3" || page[1].ID != "m2" {
+		t.Errorf("inbox after changes = %v, want m3 and m2", page)
+	}
+	if state := reopened.State(); state.HistoryID != 7 {
+		t.Errorf("reopened state = %+v, want history ID 7", state)
+	}
+}
+
+func TestMailStoreEncryption(t *testing.T) {
+	keys := testKeyring(t)
+	store := newTestStore(t, keys)
+
+	err := filepath.WalkDir(store.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, plain := range []string{"Quarterly", "alice@example.com", "Clients/Acme"} {
+			if bytes.Contains(b, []byte(plain)) {
+				t.Errorf("%s holds %q in plain text", path, plain)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenMailStore(store.dir, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := ParseMailQuery(`"quarterly report"`)
+	if page, _ := reopened.Search(q, 0, 10); len(page) != 1 || page[0].ID != "m1" {
+		t.Errorf("Search after reopening = %v, want m1", page)
+	}
+
+	// A swapped message file does not authenticate under its new name.
+	m1, m2 := store.messagePath("m1"), store.messagePath("m2")
+	b, err := os.ReadFile(m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(m2, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	swapped, err := OpenMailStore(store.dir, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := swapped.messages["m2"]; ok {
+		t.Error("a message file copied over another was accepted")
+	}
+
+	// Other keys cannot read the store, which is emptied for a refill.
+	other, err := OpenMailStore(store.dir, testKeyring(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := other.Stats(); stats.Messages != 0 || stats.State.HistoryID != 0 {
+		t.Errorf("store opened with other keys holds %d messages at history ID %d", stats.Messages, stats.State.HistoryID)
+	}
+}
+
+func TestMailStoreStateCovers(t *testing.T) {
+	since := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	truncated := MailStoreState{Truncated: true, Since: since}
+	tests := []struct {
+		state MailStoreState
+		query string
+		want  bool
+	}{
+		{MailStoreState{}, "report", true},
+		{MailStoreState{}, "before:2020/01/01", true},
+		{truncated, "report", false},
+		{truncated, "before:2024/06/01", false},
+		{truncated, "after:2024/02/28", false},
+		{truncated, "after:2024/03/01", true},
+		{truncated, "after:2024/03/02 report", true},
+	}
+	for _, tt := range tests {
+		q, err := ParseMailQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.state.Covers(q); got != tt.want {
+			t.Errorf("Covers(%q) with truncated=%v = %v, want %v", tt.query, tt.state.Truncated, got, tt.want)
+		}
+	}
+}
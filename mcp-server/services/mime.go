@@ -0,0 +1,274 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Attachment is a file attached to an Email. Inline attachments are images
+// referenced from the HTML body as "cid:<ContentID>".
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+	Inline      bool
+	ContentID   string
+}
+
+// Email is an outgoing message. Bytes renders it as RFC 5322 / MIME.
+type Email struct {
+	// From is optional; Gmail uses the account address when it is empty and
+	// only honours send-as aliases configured for the account.
+	From    string
+	To      []string
+	Cc      []string
+	Bcc     []string
+	Subject string
+	Text    string
+	HTML    string
+	// InReplyTo and References thread a reply or forward under the
+	// message it answers.
+	InReplyTo   string
+	References  string
+	Attachments []Attachment
+}
+
+// ParseAddressList parses a comma separated address list. CR and LF are
+// rejected outright so recipients cannot smuggle in extra headers.
+func ParseAddressList(list string) ([]*mail.Address, error) {
+	if strings.ContainsAny(list, "\r\n") {
+		return nil, errors.New("address list must not contain line breaks")
+	}
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	addrs, err := mail.ParseAddressList(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address list %q: %w", list, err)
+	}
+	return addrs, nil
+}
+
+// formatAddresses re-encodes addresses, RFC 2047 encoding display names.
+func formatAddresses(field string, list []string) (string, error) {
+	var out []string
+	for _, item := range list {
+		addrs, err := ParseAddressList(item)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", field, err)
+		}
+		for _, addr := range addrs {
+			out = append(out, addr.String())
+		}
+	}
+	return strings.Join(out, ", "), nil
+}
+
+// Recipients returns every To, Cc and Bcc address.
+func (e *Email) Recipients() ([]*mail.Address, error) {
+	var all []*mail.Address
+	for _, item := range append(append(append([]string{}, e.To...), e.Cc...), e.Bcc...) {
+		addrs, err := ParseAddressList(item)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, addrs...)
+	}
+	return all, nil
+}
+
+// Bytes renders the message: text and HTML become multipart/alternative,
+// inline images wrap the body in multipart/related and attachments wrap
+// everything in multipart/mixed. Single parts are sent as is.
+func (e *Email) Bytes() ([]byte, error) {
+	from := ""
+	if e.From != "" {
+		addr, err := ParseAddressList(e.From)
+		if err != nil || len(addr) != 1 {
+			return nil, fmt.Errorf("from must be a single address: %q", e.From)
+		}
+		from = addr[0].String()
+	}
+	to, err := formatAddresses("to", e.To)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := formatAddresses("cc", e.Cc)
+	if err != nil {
+		return nil, err
+	}
+	bcc, err := formatAddresses("bcc", e.Bcc)
+	if err != nil {
+		return nil, err
+	}
+	if to == "" && cc == "" && bcc == "" {
+		return nil, errors.New("at least one recipient is required")
+	}
+	var buf bytes.Buffer
+	for _, h := range []struct{ name, value string }{
+		{"From", from},
+		{"To", to},
+		{"Cc", cc},
+		// Gmail removes Bcc from the delivered copies.
+		{"Bcc", bcc},
+		{"Subject", mime.QEncoding.Encode("UTF-8", e.Subject)},
+		{"In-Reply-To", e.InReplyTo},
+		{"References", e.References},
+		{"MIME-Version", "1.0"},
+	} {
+		if strings.ContainsAny(h.value, "\r\n") {
+			return nil, fmt.Errorf("%s must not contain line breaks", h.name)
+		}
+		if h.value != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", h.name, h.value)
+		}
+	}
+
+	var inline, attached []Attachment
+	for _, a := range e.Attachments {
+		if strings.ContainsAny(a.Filename+a.ContentType+a.ContentID, "\r\n") {
+			return nil, fmt.Errorf("attachment %q: line breaks are not allowed in names or types", a.Filename)
+		}
+		if a.Inline {
+			inline = append(inline, a)
+		} else {
+			attached = append(attached, a)
+		}
+	}
+	if len(inline) > 0 && e.HTML == "" {
+		return nil, errors.New("inline images need an HTML body that references them")
+	}
+
+	root := e.bodyPart()
+	if len(inline) > 0 {
+		related := &mimePart{contentType: "multipart/related", children: []*mimePart{root}}
+		for _, a := range inline {
+			related.children = append(related.children, attachmentPart(a))
+		}
+		root = related
+	}
+	if len(attached) > 0 {
+		mixed := &mimePart{contentType: "multipart/mixed", children: []*mimePart{root}}
+		for _, a := range attached {
+			mixed.children = append(mixed.children, attachmentPart(a))
+		}
+		root = mixed
+	}
+
+	if err := root.write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bodyPart returns the text and/or HTML body.
+func (e *Email) bodyPart() *mimePart {
+	text := &mimePart{contentType: `text/plain; charset="UTF-8"`, data: []byte(e.Text)}
+	if e.HTML == "" {
+		return text
+	}
+	html := &mimePart{contentType: `text/html; charset="UTF-8"`, data: []byte(e.HTML)}
+	if e.Text == "" {
+		return html
+	}
+	return &mimePart{contentType: "multipart/alternative", children: []*mimePart{text, html}}
+}
+
+func attachmentPart(a Attachment) *mimePart {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(strings.ToLower(fileExt(a.Filename)))
+	}
+	contentType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		contentType = "application/octet-stream"
+	}
+	p := &mimePart{
+		contentType: mime.FormatMediaType(contentType, map[string]string{"name": a.Filename}),
+		data:        a.Data,
+		header:      textproto.MIMEHeader{},
+	}
+	disposition := "attachment"
+	if a.Inline {
+		disposition = "inline"
+		p.header.Set("Content-ID", "<"+strings.Trim(a.ContentID, "<>")+">")
+	}
+	p.header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	return p
+}
+
+func fileExt(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i:]
+	}
+	return ""
+}
+
+// mimePart is a node of the MIME tree: a leaf with data or a multipart
+// container with children.
+type mimePart struct {
+	contentType string
+	header      textproto.MIMEHeader
+	data        []byte
+	children    []*mimePart
+}
+
+// write renders the part's Content-* headers and body.
+func (p *mimePart) write(w io.Writer) error {
+	var boundary string
+	contentType := p.contentType
+	if len(p.children) > 0 {
+		boundary = randomBoundary()
+		contentType = mime.FormatMediaType(p.contentType, map[string]string{"boundary": boundary})
+	}
+	fmt.Fprintf(w, "Content-Type: %s\r\n", contentType)
+	names := make([]string, 0, len(p.header))
+	for name := range p.header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %s\r\n", name, p.header.Get(name))
+	}
+	if len(p.children) == 0 {
+		io.WriteString(w, "Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(w, p.data)
+		return nil
+	}
+
+	io.WriteString(w, "\r\n")
+	for _, child := range p.children {
+		fmt.Fprintf(w, "--%s\r\n", boundary)
+		if err := child.write(w); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "--%s--\r\n", boundary)
+	return nil
+}
+
+func randomBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "b" + hex.EncodeToString(b)
+}
+
+// writeBase64 writes data base64 encoded in 76 character lines.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
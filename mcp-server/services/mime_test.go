@@ -0,0 +1,225 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mimeTree describes a parsed MIME part as its media type followed, for
+// multiparts, by its children in brackets.
+func mimeTree(t *testing.T, contentType string, body io.Reader, leaves map[string][]byte) string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Content-Type %q: %v", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, body))
+		if err != nil {
+			t.Fatalf("%s body: %v", mediaType, err)
+		}
+		leaves[mediaType] = data
+		return mediaType
+	}
+	var children []string
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := r.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		child := mimeTree(t, part.Header.Get("Content-Type"), part, leaves)
+		if id := part.Header.Get("Content-Id"); id != "" {
+			child += " " + id
+		}
+		children = append(children, child)
+	}
+	return mediaType + "[" + strings.Join(children, ", ") + "]"
+}
+
+func TestEmailBytesStructure(t *testing.T) {
+	image := []byte("\x89PNG fake image")
+	pdf := []byte("%PDF-1.4 fake")
+	tests := []struct {
+		name  string
+		email Email
+		want  string
+	}{
+		{
+			name:  "text only",
+			email: Email{To: []string{"a@example.com"}, Text: "hello"},
+			want:  "text/plain",
+		},
+		{
+			name:  "text and html",
+			email: Email{To: []string{"a@example.com"}, Text: "hello", HTML: "<p>hello</p>"},
+			want:  "multipart/alternative[text/plain, text/html]",
+		},
+		{
+			name: "inline image",
+			email: Email{To: []string{"a@example.com"}, Text: "hello", HTML: `<img src="cid:logo">`, Attachments: []Attachment{
+				{Filename: "logo.png", Data: image, Inline: true, ContentID: "logo"},
+			}},
+			want: "multipart/related[multipart/alternative[text/plain, text/html], image/png <logo>]",
+		},
+		{
+			name: "inline image and attachment",
+			email: Email{To: []string{"a@example.com"}, HTML: `<img src="cid:logo">`, Attachments: []Attachment{
+				{Filename: "report.pdf", Data: pdf},
+				{Filename: "logo.png", Data: image, Inline: true, ContentID: "<logo>"},
+			}},
+			want: "multipart/mixed[multipart/related[text/html, image/png <logo>], application/pdf]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := tt.email.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaves := make(map[string][]byte)
+			if got := mimeTree(t, msg.Header.Get("Content-Type"), msg.Body, leaves); got != tt.want {
+				t.Errorf("structure = %s, want %s", got, tt.want)
+			}
+			for _, a := range tt.email.Attachments {
+				mediaType := mime.TypeByExtension(fileExt(a.Filename))
+				if !bytes.Equal(leaves[mediaType], a.Data) {
+					t.Errorf("%s content = %q, want %q", a.Filename, leaves[mediaType], a.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestEmailBytesEncodedHeaders(t *testing.T) {
+	email := Email{
+		To:      []string{"Zoë Müller <zoe@example.com>"},
+		Subject: "Café ☕ meeting",
+		Text:    "hi",
+	}
+	raw, err := email.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject := msg.Header.Get("Subject")
+	if !strings.HasPrefix(subject, "=?UTF-8?") {
+		t.Errorf("Subject %q is not RFC 2047 encoded", subject)
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err != nil || decoded != email.Subject {
+		t.Errorf("Subject decodes to %q, %v; want %q", decoded, err, email.Subject)
+	}
+	if to := msg.Header.Get("To"); !strings.Contains(to, "=?utf-8?") {
+		t.Errorf("To %q does not encode the display name", to)
+	}
+	addrs, err := msg.Header.AddressList("To")
+	if err != nil || len(addrs) != 1 || addrs[0].Name != "Zoë Müller" || addrs[0].Address != "zoe@example.com" {
+		t.Errorf("To parses as %v, %v", addrs, err)
+	}
+}
+
+func TestEmailBytesHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name  string
+		email Email
+	}{
+		{"to", Email{To: []string{"a@example.com\r\nBcc: evil@example.com"}, Text: "x"}},
+		{"cc", Email{To: []string{"a@example.com"}, Cc: []string{"b@example.com\nBcc: evil@example.com"}, Text: "x"}},
+		{"from", Email{From: "me@example.com\r\nBcc: evil@example.com", To: []string{"a@example.com"}, Text: "x"}},
+		{"in-reply-to", Email{To: []string{"a@example.com"}, InReplyTo: "<id@example.com>\r\nBcc: evil@example.com", Text: "x"}},
+		{"references", Email{To: []string{"a@example.com"}, References: "<id@example.com>\nBcc: evil@example.com", Text: "x"}},
+		{"attachment name", Email{To: []string{"a@example.com"}, Text: "x", Attachments: []Attachment{
+			{Filename: "a.txt\r\nBcc: evil@example.com", Data: []byte("x")},
+		}}},
+		{"content id", Email{To: []string{"a@example.com"}, HTML: "x", Attachments: []Attachment{
+			{Filename: "a.png", Data: []byte("x"), Inline: true, ContentID: "a\r\nBcc: evil@example.com"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if raw, err := tt.email.Bytes(); err == nil {
+				t.Errorf("Bytes accepted a line break:\n%s", raw)
+			}
+		})
+	}
+
+	// A subject with line breaks is encoded rather than refused, so it
+	// cannot start a header either.
+	email := Email{To: []string{"a@example.com"}, Subject: "Hi\r\nBcc: evil@example.com", Text: "x"}
+	raw, err := email.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("subject added Bcc: %s", bcc)
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"  ", nil, false},
+		{"a@example.com", []string{"a@example.com"}, false},
+		{`"Doe, Jane" <jane@example.com>, b@example.com`, []string{"jane@example.com", "b@example.com"}, false},
+		{"a@example.com\r\nBcc: evil@example.com", nil, true},
+		{"a@example.com\n", nil, true},
+		{"not an address", nil, true},
+	}
+	for _, tt := range tests {
+		addrs, err := ParseAddressList(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAddressList(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, a := range addrs {
+			got = append(got, a.Address)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAddressList(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestEmailBytesRefusals(t *testing.T) {
+	tests := []struct {
+		name  string
+		email Email
+	}{
+		{"no recipient", Email{Text: "x"}},
+		{"two senders", Email{From: "a@example.com, b@example.com", To: []string{"c@example.com"}, Text: "x"}},
+		{"inline image without html", Email{To: []string{"a@example.com"}, Text: "x", Attachments: []Attachment{
+			{Filename: "a.png", Data: []byte("x"), Inline: true, ContentID: "a"},
+		}}},
+	}
+	for _, tt := range tests {
+		if _, err := tt.email.Bytes(); err == nil {
+			t.Errorf("%s: Bytes succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultAccount is the account used when none is named.
+const DefaultAccount = "default"
+
+var accountName = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+// GooglePaths locates the OAuth client credentials and the per-account token files.
+type GooglePaths struct {
+	CredentialsFile string
+	TokenDir        string
+}
+
+// GooglePathsFromEnv reads GOOGLE_CREDENTIALS_FILE and GOOGLE_TOKEN_DIR,
+// defaulting to the google-credential directory in the working directory.
+func GooglePathsFromEnv() GooglePaths {
+	pwd, _ := os.Getwd()
+	p := GooglePaths{
+		CredentialsFile: os.Getenv("GOOGLE_CREDENTIALS_FILE"),
+		TokenDir:        os.Getenv("GOOGLE_TOKEN_DIR"),
+	}
+	if p.CredentialsFile == "" {
+		p.CredentialsFile = filepath.Join(pwd, "google-credential", "credentials.json")
+	}
+	if p.TokenDir == "" {
+		p.TokenDir = filepath.Join(pwd, "google-credential")
+	}
+	return p
+}
+
+// ValidateAccount rejects account names that are unsafe in file names.
+func ValidateAccount(account string) error {
+	if !accountName.MatchString(account) {
+		return fmt.Errorf("invalid account name %q: use letters, digits, '.', '_', '@' or '-'", account)
+	}
+	return nil
+}
+
+// TokenFile returns the token file of account. The default account keeps
+// the historical token.json name.
+func (p GooglePaths) TokenFile(account string) string {
+	if account == "" || account == DefaultAccount {
+		return filepath.Join(p.TokenDir, "token.json")
+	}
+	return filepath.Join(p.TokenDir, "token-"+account+".json")
+}
+
+// Accounts lists the accounts that have a token file, sorted.
+func (p GooglePaths) Accounts() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(p.TokenDir, "token*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var accounts []string
+	for _, m := range matches {
+		name := filepath.Base(m)
+		switch {
+		case name == "token.json":
+			accounts = append(accounts, DefaultAccount)
+		case len(name) > len("token-.json"):
+			accounts = append(accounts, name[len("token-"):len(name)-len(".json")])
+		}
+	}
+	return accounts, nil
+}
+
+// LoginOptions configures LoginLoopback.
+type LoginOptions struct {
+	// ListenAddr is the loopback address for the redirect listener; the
+	// default "127.0.0.1:0" picks a free port.
+	ListenAddr string
+	// Timeout bounds how long to wait for the browser to come back.
+	Timeout time.Duration
+	// OpenURL is called with the consent URL, e.g. to launch a browser.
+	OpenURL func(url string)
+}
+
+// LoginLoopback runs the OAuth authorization code flow for installed apps:
+// it listens on a loopback address for the redirect, protects the exchange
+// with PKCE and verifies the state parameter.
+func LoginLoopback(ctx context.Context, config *oauth2.Config, opts LoginOptions) (*oauth2.Token, error) {
+	if opts.ListenAddr == "" {
+		opts.ListenAddr = "127.0.0.1:0"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+
+	listener, err := net.Listen("tcp", opts.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start redirect listener: %w", err)
+	}
+	defer listener.Close()
+
+	cfg := *config
+	cfg.RedirectURL = fmt.Sprintf("http://%s/callback", listener.Addr().String())
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		// A request without our state did not come from the authorization
+		// server's redirect, so it must not end the login.
+		if q.Get("state") != state {
+			http.Error(w, "state mismatch in OAuth redirect", http.StatusBadRequest)
+			return
+		}
+		var res result
+		switch {
+		case q.Get("error") != "":
+			res.err = fmt.Errorf("authorization denied: %s", q.Get("error"))
+		case q.Get("code") == "":
+			res.err = errors.New("OAuth redirect carried no code")
+		default:
+			res.code = q.Get("code")
+		}
+		if res.err != nil {
+			http.Error(w, html.EscapeString(res.err.Error()), http.StatusBadRequest)
+		} else {
+			fmt.Fprint(w, "Authorization complete. You can close this window.")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	authURL := cfg.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("prompt", "consent"),
+		oauth2.S256ChallengeOption(verifier))
+	if opts.OpenURL != nil {
+		opts.OpenURL(authURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for authorization: %w", ctx.Err())
+	case res := <-results:
+		if res.err != nil {
+			return nil, res.err
+		}
+		tok, err := cfg.Exchange(ctx, res.code, oauth2.VerifierOption(verifier))
+		if err != nil {
+			return nil, fmt.Errorf("unable to exchange authorization code: %w", err)
+		}
+		return tok, nil
+	}
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
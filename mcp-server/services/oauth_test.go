@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeAuthServer is a token endpoint that only hands out a token for code
+// together with the verifier of the challenge the consent URL carried.
+func fakeAuthServer(t *testing.T, code string, challenge *string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("token request: %v", err)
+		}
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		switch {
+		case r.URL.Path != "/token" || r.Form.Get("grant_type") != "authorization_code":
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		case r.Form.Get("code") != code:
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		case base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge:
+			http.Error(w, `{"error":"invalid_grant","error_description":"PKCE verification failed"}`, http.StatusBadRequest)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLoginLoopback(t *testing.T) {
+	var challenge string
+	auth := fakeAuthServer(t, "good-code", &challenge)
+	config := &oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{AuthURL: auth.URL + "/auth", TokenURL: auth.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+	}
+
+	callbacks := make(chan int, 2)
+	openURL := func(consent string) {
+		u, err := url.Parse(consent)
+		if err != nil {
+			t.Errorf("consent URL: %v", err)
+			return
+		}
+		q := u.Query()
+		if q.Get("code_challenge_method") != "S256" {
+			t.Errorf("consent URL without an S256 challenge: %s", consent)
+		}
+		challenge = q.Get("code_challenge")
+		redirect := q.Get("redirect_uri")
+		go func() {
+			// A forged redirect is refused without ending the login.
+			for _, state := range []string{"forged", q.Get("state")} {
+				resp, err := http.Get(redirect + "?" + url.Values{"state": {state}, "code": {"good-code"}}.Encode())
+				if err != nil {
+					t.Errorf("callback: %v", err)
+					return
+				}
+				resp.Body.Close()
+				callbacks <- resp.StatusCode
+			}
+		}()
+	}
+
+	tok, err := LoginLoopback(context.Background(), config, LoginOptions{Timeout: 10 * time.Second, OpenURL: openURL})
+	if err != nil {
+		t.Fatalf("LoginLoopback: %v", err)
+	}
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" {
+		t.Errorf("token = %+v", tok)
+	}
+	if forged, real := <-callbacks, <-callbacks; forged != http.StatusBadRequest || real != http.StatusOK {
+		t.Errorf("callbacks answered %d and %d, want 400 and 200", forged, real)
+	}
+}
+
+func TestLoginLoopbackDenied(t *testing.T) {
+	var challenge string
+	auth := fakeAuthServer(t, "good-code", &challenge)
+	config := &oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{AuthURL: auth.URL + "/auth", TokenURL: auth.URL + "/token"},
+	}
+	openURL := func(consent string) {
+		u, _ := url.Parse(consent)
+		q := u.Query()
+		go func() {
+			resp, err := http.Get(q.Get("redirect_uri") + "?" + url.Values{"state": {q.Get("state")}, "error": {"access_denied"}}.Encode())
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	if _, err := LoginLoopback(context.Background(), config, LoginOptions{Timeout: 10 * time.Second, OpenURL: openURL}); err == nil {
+		t.Error("LoginLoopback succeeded after the user denied access")
+	}
+}
+
+func TestLoginLoopbackTimeout(t *testing.T) {
+	config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{AuthURL: "http://127.0.0.1/auth", TokenURL: "http://127.0.0.1/token"}}
+	if _, err := LoginLoopback(context.Background(), config, LoginOptions{Timeout: 50 * time.Millisecond}); err == nil {
+		t.Error("LoginLoopback returned without a redirect")
+	}
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrNoTextLayer is returned by PDFText for PDFs without extractable text,
+// such as scans.
+var ErrNoTextLayer = errors.New("PDF has no extractable text layer")
+
+// pdfStream matches the stream keyword but not the end of endstream, which
+// Go's regexp cannot exclude with a lookbehind.
+var (
+	pdfStream     = regexp.MustCompile(`(?:^|[^d])stream\r?\n`)
+	pdfFlate      = regexp.MustCompile(`/Filter\s*\[?\s*/FlateDecode\s*\]?`)
+	pdfOtherCodec = regexp.MustCompile(`/Filter\s*\[?\s*/(DCTDecode|JPXDecode|CCITTFaxDecode|JBIG2Decode|LZWDecode)`)
+)
+
+// maxPDFStream bounds the size of one decompressed stream.
+const maxPDFStream = 32 << 20
+
+// PDFText extracts the text drawn by the content streams of a PDF. It is a
+// best effort reader: it understands uncompressed and Flate compressed
+// streams and fonts with single byte or UTF-16 encodings, which covers most
+// generated documents, but not custom glyph mappings.
+func PDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF")) {
+		return "", errors.New("not a PDF file")
+	}
+	var b strings.Builder
+	for _, loc := range pdfStream.FindAllIndex(data, -1) {
+		// The stream dictionary sits between the object header and "stream".
+		dictStart := bytes.LastIndex(data[:loc[0]], []byte("obj"))
+		if dictStart < 0 {
+			continue
+		}
+		dict := data[dictStart:loc[0]]
+		end := bytes.Index(data[loc[1]:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[loc[1] : loc[1]+end]
+
+		switch {
+		case pdfOtherCodec.Match(dict):
+			continue
+		case pdfFlate.Match(dict):
+			r, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			decoded, err := io.ReadAll(io.LimitReader(r, maxPDFStream))
+			r.Close()
+			// Streams are often truncated by a few bytes of padding; keep
+			// whatever was inflated.
+			if len(decoded) == 0 && err != nil {
+				continue
+			}
+			raw = decoded
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue
+		}
+		if text := contentStreamText(raw); text != "" {
+			b.WriteString(text)
+			b.WriteString("\n")
+		}
+	}
+	text := collapseBlankLines(b.String())
+	if text == "" {
+		return "", ErrNoTextLayer
+	}
+	return text, nil
+}
+
+// contentStreamText interprets the text operators of a content stream.
+func contentStreamText(stream []byte) string {
+	if !bytes.Contains(stream, []byte("BT")) {
+		return ""
+	}
+	var b strings.Builder
+	var operands []pdfToken
+	inText := false
+	lex := &pdfLexer{data: stream}
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			break
+		}
+		if tok.kind != pdfOperator {
+			operands = append(operands, tok)
+			continue
+		}
+		switch tok.text {
+		case "BT":
+			inText = true
+		case "ET":
+			inText = false
+			b.WriteString("\n")
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, err := strconv.ParseFloat(operands[len(operands)-1].text, 64); err == nil && ty != 0 {
+					b.WriteString("\n")
+				} else {
+					b.WriteString(" ")
+				}
+			}
+		case "T*", "Tm":
+			b.WriteString("\n")
+		case "Tj", "'", "\"":
+			if tok.text != "Tj" {
+				b.WriteString("\n")
+			}
+			if inText && len(operands) > 0 {
+				b.WriteString(decodePDFString(operands[len(operands)-1]))
+			}
+		case "TJ":
+			if !inText {
+				break
+			}
+			for _, op := range operands {
+				switch op.kind {
+				case pdfString, pdfHexString:
+					b.WriteString(decodePDFString(op))
+				case pdfNumber:
+					// Large negative kerning separates words.
+					if n, err := strconv.ParseFloat(op.text, 64); err == nil && n < -200 {
+						b.WriteString(" ")
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return b.String()
+}
+
+type pdfTokenKind int
+
+const (
+	pdfOperator pdfTokenKind = iota
+	pdfNumber
+	pdfString
+	pdfHexString
+	pdfOther
+)
+
+type pdfToken struct {
+	kind pdfTokenKind
+	text string
+}
+
+// pdfLexer splits a content stream into operands and operators. Arrays are
+// flattened, which is all the TJ operator needs.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c) || c == '[' || c == ']':
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return pdfToken{kind: pdfString, text: l.literalString()}, true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			l.pos += 2
+			return pdfToken{kind: pdfOther, text: "<<"}, true
+		case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			l.pos += 2
+			return pdfToken{kind: pdfOther, text: ">>"}, true
+		case c == '<':
+			end := bytes.IndexByte(l.data[l.pos:], '>')
+			if end < 0 {
+				l.pos = len(l.data)
+				return pdfToken{}, false
+			}
+			text := string(l.data[l.pos+1 : l.pos+end])
+			l.pos += end + 1
+			return pdfToken{kind: pdfHexString, text: text}, true
+		case c == '/':
+			start := l.pos
+			l.pos++
+			for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			return pdfToken{kind: pdfOther, text: string(l.data[start:l.pos])}, true
+		default:
+			start := l.pos
+			for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			if l.pos == start {
+				l.pos++
+				continue
+			}
+			word := string(l.data[start:l.pos])
+			if _, err := strconv.ParseFloat(word, 64); err == nil {
+				return pdfToken{kind: pdfNumber, text: word}, true
+			}
+			// Inline images hold binary data up to EI.
+			if word == "ID" {
+				if end := bytes.Index(l.data[l.pos:], []byte("EI")); end >= 0 {
+					l.pos += end + 2
+				} else {
+					l.pos = len(l.data)
+				}
+				continue
+			}
+			return pdfToken{kind: pdfOperator, text: word}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+// literalString reads a (...) string, handling nesting and escapes.
+func (l *pdfLexer) literalString() string {
+	var out []byte
+	depth := 0
+	l.pos++
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			if depth == 0 {
+				return string(out)
+			}
+			depth--
+			out = append(out, c)
+		case '\\':
+			if l.pos >= len(l.data) {
+				return string(out)
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r', '\n':
+				// Line continuation.
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(n))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// decodePDFString turns string operand bytes into text. Strings starting
+// with a UTF-16 byte order mark, or made of two byte codes with a zero high
+// byte, are read as UTF-16; others as PDFDocEncoding, approximated by
+// Latin-1.
+func decodePDFString(tok pdfToken) string {
+	raw := []byte(tok.text)
+	if tok.kind == pdfHexString {
+		raw = hexBytes(tok.text)
+	}
+	if len(raw) >= 2 && len(raw)%2 == 0 && (bytes.HasPrefix(raw, []byte{0xfe, 0xff}) || looksUTF16(raw)) {
+		raw = bytes.TrimPrefix(raw, []byte{0xfe, 0xff})
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			units[i] = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, 0, len(raw))
+	for _, c := range raw {
+		if c >= 0x20 || c == '\n' || c == '\t' {
+			runes = append(runes, rune(c))
+		}
+	}
+	return string(runes)
+}
+
+func looksUTF16(raw []byte) bool {
+	for i := 0; i < len(raw); i += 2 {
+		if raw[i] != 0 || raw[i+1] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func hexBytes(s string) []byte {
+	var digits []byte
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		n, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(n)
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPDFText(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"plain.pdf", "Quarterly report\nRevenue grew (slightly)\n\nSecond page"},
+		{"flate.pdf", "Quarterly report\nRevenue grew (slightly)\n\nSecond page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := PDFText(data)
+			if err != nil {
+				t.Fatalf("PDFText: %v", err)
+			}
+			if got := strings.TrimSpace(got); got != tt.want {
+				t.Errorf("PDFText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFTextErrors(t *testing.T) {
+	if _, err := PDFText([]byte("hello")); err == nil {
+		t.Error("PDFText accepted a file that is not a PDF")
+	}
+	noText := []byte("%PDF-1.4\n1 0 obj\n<< /Length 9 >>\nstream\n0 0 m 1 1 l\nendstream\nendobj\n")
+	if _, err := PDFText(noText); !errors.Is(err, ErrNoTextLayer) {
+		t.Errorf("PDFText without text = %v, want ErrNoTextLayer", err)
+	}
+}
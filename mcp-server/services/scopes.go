@@ -0,0 +1,123 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/youtube/v3"
+)
+
+// Scope profiles: the smallest grants each family of tools needs.
+const (
+	ProfileGmailReadonly   = "gmail-readonly"
+	ProfileGmailSend       = "gmail-send"
+	ProfileGmailCompose    = "gmail-compose"
+	ProfileGmailSettings   = "gmail-settings"
+	ProfileGmailModify     = "gmail-modify"
+	ProfileGmailFull       = "gmail-full"
+	ProfileCalendar        = "calendar"
+	ProfileChat            = "chat"
+	ProfileYoutubeReadonly = "youtube-readonly"
+)
+
+// ScopeProfiles maps each profile to the scopes requested for it.
+var ScopeProfiles = map[string][]string{
+	ProfileGmailReadonly: {gmail.GmailReadonlyScope},
+	ProfileGmailSend:     {gmail.GmailSendScope},
+	ProfileGmailCompose:  {gmail.GmailComposeScope},
+	ProfileGmailSettings: {gmail.GmailSettingsBasicScope},
+	ProfileGmailModify:   {gmail.GmailModifyScope},
+	ProfileGmailFull: {
+		gmail.GmailModifyScope,
+		gmail.GmailLabelsScope,
+		gmail.GmailSettingsBasicScope,
+	},
+	ProfileCalendar: {calendar.CalendarEventsScope},
+	ProfileChat: {
+		"https://www.googleapis.com/auth/chat.messages",
+		"https://www.googleapis.com/auth/chat.spaces.readonly",
+	},
+	ProfileYoutubeReadonly: {youtube.YoutubeReadonlyScope},
+}
+
+// broaderScopes lists, for a scope, the scopes that include its access.
+var broaderScopes = map[string][]string{
+	gmail.GmailReadonlyScope:      {gmail.GmailModifyScope, gmail.MailGoogleComScope},
+	gmail.GmailSendScope:          {gmail.GmailComposeScope, gmail.GmailModifyScope, gmail.MailGoogleComScope},
+	gmail.GmailComposeScope:       {gmail.GmailModifyScope, gmail.MailGoogleComScope},
+	gmail.GmailModifyScope:        {gmail.MailGoogleComScope},
+	gmail.GmailLabelsScope:        {gmail.GmailModifyScope, gmail.MailGoogleComScope},
+	gmail.GmailSettingsBasicScope: {gmail.MailGoogleComScope},
+	calendar.CalendarEventsScope:  {calendar.CalendarScope},
+	youtube.YoutubeReadonlyScope:  {youtube.YoutubeScope, youtube.YoutubeForceSslScope},
+}
+
+// DefaultScopes are requested by GoogleConfig when no scopes are given. main
+// sets them from the profiles of the enabled tools.
+var DefaultScopes []string
+
+// ProfileNames returns the names of ScopeProfiles, sorted.
+func ProfileNames() []string {
+	names := make([]string, 0, len(ScopeProfiles))
+	for name := range ScopeProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileScopes returns the scopes of the named profiles with scopes covered
+// by a broader one in the same request removed.
+func ProfileScopes(profiles []string) ([]string, error) {
+	var scopes []string
+	for _, name := range profiles {
+		set, ok := ScopeProfiles[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown scope profile %q (known: %s)", name, strings.Join(ProfileNames(), ", "))
+		}
+		for _, scope := range set {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	var minimal []string
+	for _, scope := range scopes {
+		if !coveredBy(scope, scopes) {
+			minimal = append(minimal, scope)
+		}
+	}
+	return minimal, nil
+}
+
+// MissingScopes returns the scopes of profile that granted does not cover.
+func MissingScopes(granted []string, profile string) []string {
+	var missing []string
+	for _, scope := range ScopeProfiles[profile] {
+		if !slices.Contains(granted, scope) && !coveredBy(scope, granted) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+func coveredBy(scope string, scopes []string) bool {
+	for _, broader := range broaderScopes[scope] {
+		if slices.Contains(scopes, broader) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenScopes returns the scopes recorded on tok, or nil when the token does
+// not say (e.g. tokens saved before scopes were recorded).
+func TokenScopes(tok *oauth2.Token) []string {
+	scope, _ := tok.Extra("scope").(string)
+	return strings.Fields(scope)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenStore is where an OAuth token lives between runs.
+type TokenStore interface {
+	Load() (*oauth2.Token, error)
+	Save(tok *oauth2.Token) error
+}
+
+// FileTokenStore keeps a token as JSON in a single file.
+type FileTokenStore struct {
+	Path string
+}
+
+func (f FileTokenStore) Load() (*oauth2.Token, error) {
+	return TokenFromFile(f.Path)
+}
+
+// Save writes the token to a temporary file and renames it into place, so a
+// crash never leaves a truncated token behind.
+func (f FileTokenStore) Save(tok *oauth2.Token) error {
+	b, err := EncodeToken(tok)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(f.Path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".token-*")
+	if err != nil {
+		return fmt.Errorf("unable to save token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to save token: %w", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// persistingTokenSource saves every token its source hands out that differs
+// from the last one saved, i.e. after each refresh.
+type persistingTokenSource struct {
+	mu    sync.Mutex
+	src   oauth2.TokenSource
+	store TokenStore
+	last  *oauth2.Token
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := p.src.Token()
+	if err != nil {
+		return nil, ClassifyGoogleError(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last != nil && p.last.AccessToken == tok.AccessToken && p.last.RefreshToken == tok.RefreshToken {
+		return tok, nil
+	}
+	// Keep the recorded scopes when a refresh response does not repeat them.
+	if p.last != nil && len(TokenScopes(tok)) == 0 && len(TokenScopes(p.last)) > 0 {
+		tok = tok.WithExtra(map[string]interface{}{"scope": strings.Join(TokenScopes(p.last), " ")})
+	}
+	// A failed save must not fail the API call; the next refresh retries it.
+	if err := p.store.Save(tok); err != nil {
+		log.Printf("failed to persist refreshed Google token: %v", err)
+		return tok, nil
+	}
+	p.last = tok
+	return tok, nil
+}
+
+// PersistingTokenSource wraps src so refreshed tokens, including rotated
+// refresh tokens, are written back to store. initial is the token src
+// started from and is not saved again.
+func PersistingTokenSource(src oauth2.TokenSource, store TokenStore, initial *oauth2.Token) oauth2.TokenSource {
+	return &persistingTokenSource{src: src, store: store, last: initial}
+}
+
+// GoogleStoreClient returns an HTTP client authorized with the token in
+// store that writes refreshed tokens back to it.
+func GoogleStoreClient(store TokenStore, credentialsFile string) (*http.Client, error) {
+	tok, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	config, err := GoogleConfig(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	src := PersistingTokenSource(config.TokenSource(ctx, tok), store, tok)
+	return oauth2.NewClient(ctx, src), nil
+}
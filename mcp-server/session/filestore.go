@@ -0,0 +1,404 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTampered is returned when a stored entry fails authentication, either
+// because it was modified or because it was moved to another user or service.
+var ErrTampered = errors.New("credential failed integrity check")
+
+// Keyring holds AES-256 keys by ID. The first key encrypts new entries; the
+// others are only used to decrypt entries written before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// ParseKeyring parses "id:base64key[,id:base64key...]" with the primary key
+// first. A single bare base64 key is accepted and gets the ID "default".
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	for _, item := range strings.Split(strings.TrimSpace(spec), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok {
+			id, encoded = "default", item
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		if k.primary == "" {
+			k.primary = id
+		}
+		k.keys[id] = key
+	}
+	if k.primary == "" {
+		return nil, errors.New("no encryption key configured")
+	}
+	return k, nil
+}
+
+// KeyringFromEnv reads the keyring from SESSION_SECRET_KEY, or from the file
+// named by SESSION_SECRET_KEY_FILE.
+func KeyringFromEnv() (*Keyring, error) {
+	spec := os.Getenv("SESSION_SECRET_KEY")
+	if file := os.Getenv("SESSION_SECRET_KEY_FILE"); spec == "" && file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read key file: %w", err)
+		}
+		spec = string(b)
+	}
+	if spec == "" {
+		return nil, errors.New("SESSION_SECRET_KEY or SESSION_SECRET_KEY_FILE must be set for the file store")
+	}
+	return ParseKeyring(spec)
+}
+
+func (k *Keyring) aead(id string) (cipher.AEAD, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Sealed is data encrypted with a keyring key.
+type Sealed struct {
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Seal encrypts plain with the primary key. aad binds the result to where
+// it is stored: Open fails unless given the same aad.
+func (k *Keyring) Seal(plain, aad []byte) (Sealed, error) {
+	aead, err := k.aead(k.primary)
+	if err != nil {
+		return Sealed{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{KeyID: k.primary, Nonce: nonce, Ciphertext: aead.Seal(nil, nonce, plain, aad)}, nil
+}
+
+// Open decrypts sealed, failing with ErrTampered when it does not
+// authenticate.
+func (k *Keyring) Open(sealed Sealed, aad []byte) ([]byte, error) {
+	aead, err := k.aead(sealed.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, ErrTampered
+	}
+	plain, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, aad)
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return plain, nil
+}
+
+// sealedEntry is the on-disk form of one credential.
+type sealedEntry struct {
+	User       string `json:"user"`
+	Service    string `json:"service"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// plainEntry is what gets encrypted; the expiry is inside so it cannot be
+// extended without the key.
+type plainEntry struct {
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+type storeFile struct {
+	Version int           `json:"version"`
+	Entries []sealedEntry `json:"entries"`
+}
+
+// FileStore keeps credentials in a JSON file, each value sealed with
+// AES-256-GCM. The user and service are authenticated as associated data.
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	keys    *Keyring
+	entries map[string]sealedEntry
+}
+
+// NewFileStore opens or creates the store at path. Entries sealed with a
+// non-primary key are re-encrypted with the primary key, and expired entries
+// are dropped.
+func NewFileStore(path string, keys *Keyring) (*FileStore, error) {
+	f := &FileStore{path: path, keys: keys, entries: make(map[string]sealedEntry)}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	if err := f.Rotate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func entryKey(user, service string) string {
+	return user + "\x00" + service
+}
+
+func (f *FileStore) load() error {
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read session store: %w", err)
+	}
+	var file storeFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("unable to parse session store: %w", err)
+	}
+	for _, e := range file.Entries {
+		f.entries[entryKey(e.User, e.Service)] = e
+	}
+	return nil
+}
+
+// save writes the store to a temporary file and renames it over the old one.
+func (f *FileStore) save() error {
+	file := storeFile{Version: 1}
+	for _, e := range f.entries {
+		file.Entries = append(file.Entries, e)
+	}
+	sort.Slice(file.Entries, func(i, j int) bool {
+		return entryKey(file.Entries[i].User, file.Entries[i].Service) <
+			entryKey(file.Entries[j].User, file.Entries[j].Service)
+	})
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".session-store-*")
+	if err != nil {
+		return fmt.Errorf("unable to write session store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write session store: %w", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *FileStore) seal(user, service string, plain plainEntry) (sealedEntry, error) {
+	b, err := json.Marshal(plain)
+	if err != nil {
+		return sealedEntry{}, err
+	}
+	sealed, err := f.keys.Seal(b, []byte(entryKey(user, service)))
+	if err != nil {
+		return sealedEntry{}, err
+	}
+	return sealedEntry{
+		User:       user,
+		Service:    service,
+		KeyID:      sealed.KeyID,
+		Nonce:      sealed.Nonce,
+		Ciphertext: sealed.Ciphertext,
+	}, nil
+}
+
+func (f *FileStore) open(e sealedEntry) (plainEntry, error) {
+	b, err := f.keys.Open(Sealed{KeyID: e.KeyID, Nonce: e.Nonce, Ciphertext: e.Ciphertext}, []byte(entryKey(e.User, e.Service)))
+	if err != nil {
+		return plainEntry{}, err
+	}
+	var plain plainEntry
+	if err := json.Unmarshal(b, &plain); err != nil {
+		return plainEntry{}, ErrTampered
+	}
+	return plain, nil
+}
+
+func (p plainEntry) expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && now.After(p.ExpiresAt)
+}
+
+func (f *FileStore) Get(user, service string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[entryKey(user, service)]
+	if !ok {
+		return "", ErrNotFound
+	}
+	plain, err := f.open(e)
+	if err != nil {
+		return "", fmt.Errorf("%s credential for %s: %w", service, user, err)
+	}
+	if plain.expired(time.Now()) {
+		return "", ErrNotFound
+	}
+	return plain.Value, nil
+}
+
+func (f *FileStore) Set(user, service, value string, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, err := f.seal(user, service, plainEntry{Value: value, ExpiresAt: expiry(ttl)})
+	if err != nil {
+		return err
+	}
+	f.entries[entryKey(user, service)] = e
+	return f.save()
+}
+
+func (f *FileStore) Replace(user, service, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := entryKey(user, service)
+	e, ok := f.entries[key]
+	if !ok {
+		return ErrNotFound
+	}
+	plain, err := f.open(e)
+	if err != nil {
+		return fmt.Errorf("%s credential for %s: %w", service, user, err)
+	}
+	if plain.expired(time.Now()) {
+		return ErrNotFound
+	}
+	plain.Value = value
+	if e, err = f.seal(user, service, plain); err != nil {
+		return err
+	}
+	f.entries[key] = e
+	return f.save()
+}
+
+func (f *FileStore) Delete(user, service string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := entryKey(user, service)
+	e, ok := f.entries[key]
+	if !ok {
+		return false, nil
+	}
+	// An expired entry counts as absent, as in MemoryStore; one that no
+	// longer opens is still removed and reported.
+	plain, err := f.open(e)
+	delete(f.entries, key)
+	return err != nil || !plain.expired(time.Now()), f.save()
+}
+
+func (f *FileStore) List(user string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var services []string
+	for _, e := range f.entries {
+		if e.User != user {
+			continue
+		}
+		plain, err := f.open(e)
+		if err != nil {
+			log.Printf("Skipping %s credential for %s: %v", e.Service, user, err)
+			continue
+		}
+		if !plain.expired(now) {
+			services = append(services, e.Service)
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+func (f *FileStore) DeleteUser(user string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	changed := false
+	for key, e := range f.entries {
+		if e.User == user {
+			delete(f.entries, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return f.save()
+}
+
+// Rotate re-encrypts every entry not sealed with the primary key and drops
+// expired entries. Entries that fail to decrypt are logged and left as they
+// are, so one bad entry does not lock out the others. Once it has run,
+// retired keys can be removed from the keyring.
+func (f *FileStore) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	changed := false
+	for key, e := range f.entries {
+		plain, err := f.open(e)
+		if err != nil {
+			log.Printf("Skipping %s credential for %s: %v", e.Service, e.User, err)
+			continue
+		}
+		if plain.expired(now) {
+			delete(f.entries, key)
+			changed = true
+			continue
+		}
+		if e.KeyID == f.keys.primary {
+			continue
+		}
+		resealed, err := f.seal(e.User, e.Service, plain)
+		if err != nil {
+			return err
+		}
+		f.entries[key] = resealed
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return f.save()
+}
@@ -0,0 +1,263 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func mustKeyring(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	keys, err := ParseKeyring(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// editStoreFile applies edit to every entry in the store file at path.
+func editStoreFile(t *testing.T, path string, edit func(*sealedEntry)) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file storeFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		t.Fatal(err)
+	}
+	for i := range file.Entries {
+		edit(&file.Entries[i])
+	}
+	if b, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	keys := mustKeyring(t, newKey(t))
+	store, err := NewFileStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("alice", "google_token", "secret", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Get("alice", "google_token"); err != nil || got != "secret" {
+		t.Errorf("Get = %q, %v; want secret", got, err)
+	}
+	if _, err := reopened.Get("bob", "google_token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get for another user = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileStoreTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(*sealedEntry)
+		user    string
+		service string
+	}{
+		{
+			name:    "flipped ciphertext byte",
+			edit:    func(e *sealedEntry) { e.Ciphertext[0] ^= 1 },
+			user:    "alice",
+			service: "google_token",
+		},
+		{
+			name:    "moved to another user",
+			edit:    func(e *sealedEntry) { e.User = "mallory" },
+			user:    "mallory",
+			service: "google_token",
+		},
+		{
+			name:    "moved to another service",
+			edit:    func(e *sealedEntry) { e.Service = "openai" },
+			user:    "alice",
+			service: "openai",
+		},
+		{
+			name:    "shortened nonce",
+			edit:    func(e *sealedEntry) { e.Nonce = e.Nonce[1:] },
+			user:    "alice",
+			service: "google_token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.json")
+			keys := mustKeyring(t, newKey(t))
+			store, err := NewFileStore(path, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Set("alice", "google_token", "secret", 0); err != nil {
+				t.Fatal(err)
+			}
+			editStoreFile(t, path, tt.edit)
+
+			// A bad entry must not keep the store from opening.
+			reopened, err := NewFileStore(path, keys)
+			if err != nil {
+				t.Fatalf("NewFileStore with a tampered entry: %v", err)
+			}
+			if got, err := reopened.Get(tt.user, tt.service); !errors.Is(err, ErrTampered) {
+				t.Errorf("Get = %q, %v; want ErrTampered", got, err)
+			}
+		})
+	}
+}
+
+func TestFileStoreExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	keys := mustKeyring(t, newKey(t))
+	store, err := NewFileStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("alice", "google_token", "secret", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := store.Get("alice", "google_token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after expiry = %v, want ErrNotFound", err)
+	}
+	if err := store.Replace("alice", "google_token", "new"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Replace after expiry = %v, want ErrNotFound", err)
+	}
+	reopened, err := NewFileStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if services, err := reopened.List("alice"); err != nil || len(services) != 0 {
+		t.Errorf("List after expiry = %v, %v; want nothing", services, err)
+	}
+
+	// Both stores report an expired entry as absent when deleting it.
+	memory := NewMemoryStore()
+	for name, s := range map[string]SecretStore{"FileStore": reopened, "MemoryStore": memory} {
+		if err := s.Set("bob", "openai", "key", time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if existed, err := s.Delete("bob", "openai"); err != nil || existed {
+			t.Errorf("%s: Delete after expiry = %v, %v; want false", name, existed, err)
+		}
+	}
+	if existed, err := reopened.Delete("alice", "google_token"); err != nil || existed {
+		t.Errorf("Delete after reopening = %v, %v; want false", existed, err)
+	}
+}
+
+func TestFileStoreListSkipsBadEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	keys := mustKeyring(t, newKey(t))
+	store, err := NewFileStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, service := range []string{"anthropic", "google_token", "openai"} {
+		if err := store.Set("alice", service, "secret", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	editStoreFile(t, path, func(e *sealedEntry) {
+		if e.Service == "google_token" {
+			e.Ciphertext[0] ^= 1
+		}
+	})
+
+	reopened, err := NewFileStore(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"anthropic", "openai"}
+	if services, err := reopened.List("alice"); err != nil || !reflect.DeepEqual(services, want) {
+		t.Errorf("List = %v, %v; want %v", services, err, want)
+	}
+	// The bad entry can still be cleared.
+	if existed, err := reopened.Delete("alice", "google_token"); err != nil || !existed {
+		t.Errorf("Delete of a tampered entry = %v, %v; want true", existed, err)
+	}
+}
+
+func TestFileStoreKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	oldKey, newKeyValue := newKey(t), newKey(t)
+
+	store, err := NewFileStore(path, mustKeyring(t, "old:"+oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("alice", "google_token", "secret", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening with a new primary key re-encrypts entries written before.
+	rotated, err := NewFileStore(path, mustKeyring(t, "new:"+newKeyValue+",old:"+oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := rotated.Get("alice", "google_token"); err != nil || got != "secret" {
+		t.Errorf("Get after rotation = %q, %v; want secret", got, err)
+	}
+	editStoreFile(t, path, func(e *sealedEntry) {
+		if e.KeyID != "new" {
+			t.Errorf("entry still sealed with key %q", e.KeyID)
+		}
+	})
+
+	// The retired key is no longer needed.
+	retired, err := NewFileStore(path, mustKeyring(t, "new:"+newKeyValue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := retired.Get("alice", "google_token"); err != nil || got != "secret" {
+		t.Errorf("Get without the old key = %q, %v; want secret", got, err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := newKey(t)
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{key, false},
+		{"a:" + key + ",b:" + newKey(t), false},
+		{"", true},
+		{"a:not base64!", true},
+		{"a:" + base64.StdEncoding.EncodeToString([]byte("short")), true},
+		{"a:" + key + ",a:" + key, true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseKeyring(tt.spec); (err != nil) != tt.wantErr {
+			t.Errorf("ParseKeyring(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
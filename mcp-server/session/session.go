@@ -1 +1,99 @@
 package session
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Services whose credentials can be stored per user.
+const (
+	ServiceBrave       = "brave"
+	ServiceGoogleToken = "google_token"
+	ServiceAWSProfile  = "aws_profile"
+	// ServiceGoogleAccount is the Workspace user the server's service
+	// account acts as for this caller.
+	ServiceGoogleAccount = "google_account"
+)
+
+// Services lists every service accepted by Set.
+var Services = []string{ServiceBrave, ServiceGoogleToken, ServiceAWSProfile, ServiceGoogleAccount}
+
+// anonymousUser is used when neither the transport nor the client session
+// identifies the caller (e.g. direct handler calls).
+const anonymousUser = "default"
+
+type userKey struct{}
+
+// WithUser attaches an authenticated user identity to ctx, typically from a
+// gateway supplied HTTP header. An empty user leaves ctx unchanged.
+func WithUser(ctx context.Context, user string) context.Context {
+	if user == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserID returns the identity credentials are stored under: the user set by
+// WithUser, else the MCP client session ID.
+func UserID(ctx context.Context) string {
+	if user, ok := ctx.Value(userKey{}).(string); ok {
+		return user
+	}
+	if cs := server.ClientSessionFromContext(ctx); cs != nil {
+		return cs.SessionID()
+	}
+	return anonymousUser
+}
+
+var defaultStore SecretStore = NewMemoryStore()
+
+// SetStore replaces the store used by the package level accessors. It must
+// be called before the server starts handling requests.
+func SetStore(s SecretStore) {
+	defaultStore = s
+}
+
+// Set stores value as the caller's credential for service. A zero ttl never expires.
+func Set(ctx context.Context, service, value string, ttl time.Duration) error {
+	if !slices.Contains(Services, service) {
+		return fmt.Errorf("unknown service %q", service)
+	}
+	if value == "" {
+		return fmt.Errorf("value for %s must not be empty", service)
+	}
+	return defaultStore.Set(UserID(ctx), service, value, ttl)
+}
+
+// Replace updates the caller's existing credential for service, keeping its
+// expiry. It returns ErrNotFound if the credential was cleared or expired.
+func Replace(ctx context.Context, service, value string) error {
+	return defaultStore.Replace(UserID(ctx), service, value)
+}
+
+// Get returns the caller's credential for service, or ErrNotFound.
+func Get(ctx context.Context, service string) (string, error) {
+	return defaultStore.Get(UserID(ctx), service)
+}
+
+// Clear removes the caller's credential for service and reports whether one was set.
+func Clear(ctx context.Context, service string) (bool, error) {
+	return defaultStore.Delete(UserID(ctx), service)
+}
+
+// List returns the services the caller has credentials for, sorted.
+func List(ctx context.Context) ([]string, error) {
+	return defaultStore.List(UserID(ctx))
+}
+
+// Forget drops credentials keyed by a client session ID once that session
+// ends. Credentials of users identified by WithUser are kept.
+func Forget(ctx context.Context, cs server.ClientSession) {
+	if err := defaultStore.DeleteUser(cs.SessionID()); err != nil {
+		log.Printf("failed to drop credentials of session %s: %v", cs.SessionID(), err)
+	}
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no live credential exists for a user and service.
+var ErrNotFound = errors.New("credential not found")
+
+// SecretStore persists credentials by user and service.
+type SecretStore interface {
+	// Get returns the value, or ErrNotFound if missing or expired.
+	Get(user, service string) (string, error)
+	// Set stores value; a zero ttl never expires.
+	Set(user, service, value string, ttl time.Duration) error
+	// Replace updates an existing live value, keeping its expiry, or
+	// returns ErrNotFound.
+	Replace(user, service, value string) error
+	// Delete removes the value and reports whether one existed.
+	Delete(user, service string) (bool, error)
+	// List returns the services with a live value for user, sorted.
+	List(user string) ([]string, error)
+	// DeleteUser removes every value stored for user.
+	DeleteUser(user string) error
+}
+
+// StoreFromEnv builds the store selected by SESSION_STORE: "memory" (the
+// default) or "file", which is written to SESSION_STORE_PATH and encrypted
+// with the keys from SESSION_SECRET_KEY or SESSION_SECRET_KEY_FILE.
+func StoreFromEnv() (SecretStore, error) {
+	switch kind := os.Getenv("SESSION_STORE"); kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		keys, err := KeyringFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		path := os.Getenv("SESSION_STORE_PATH")
+		if path == "" {
+			path = "session-secrets.json"
+		}
+		return NewFileStore(path, keys)
+	default:
+		return nil, fmt.Errorf("unknown SESSION_STORE %q (expected memory or file)", kind)
+	}
+}
+
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// MemoryStore keeps credentials in process memory only.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]map[string]memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]map[string]memoryEntry)}
+}
+
+func (m *MemoryStore) Get(user, service string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[user][service]
+	if !ok || entry.expired(time.Now()) {
+		return "", ErrNotFound
+	}
+	return entry.value, nil
+}
+
+func (m *MemoryStore) Set(user, service, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries[user] == nil {
+		m.entries[user] = make(map[string]memoryEntry)
+	}
+	m.entries[user][service] = memoryEntry{value: value, expiresAt: expiry(ttl)}
+	return nil
+}
+
+func (m *MemoryStore) Replace(user, service, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[user][service]
+	if !ok || entry.expired(time.Now()) {
+		return ErrNotFound
+	}
+	entry.value = value
+	m.entries[user][service] = entry
+	return nil
+}
+
+func (m *MemoryStore) Delete(user, service string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[user][service]
+	if !ok {
+		return false, nil
+	}
+	delete(m.entries[user], service)
+	return !entry.expired(time.Now()), nil
+}
+
+func (m *MemoryStore) List(user string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	var services []string
+	for service, entry := range m.entries[user] {
+		if !entry.expired(now) {
+			services = append(services, service)
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+func (m *MemoryStore) DeleteUser(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, user)
+	return nil
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
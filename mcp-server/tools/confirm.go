@@ -0,0 +1,290 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultConfirmTools need confirmation unless CONFIRM_TOOLS says otherwise:
+// everything that sends mail, deletes it or changes how Gmail handles mail
+// from now on, and saving attachments on the server.
+var defaultConfirmTools = []string{
+	"gmail_send_email",
+	"gmail_reply",
+	"gmail_forward",
+	"gmail_send_draft",
+	"gmail_delete_draft",
+	"gmail_mail_merge",
+	"gmail_trash",
+	"gmail_mark_spam",
+	"gmail_create_filter",
+	"gmail_delete_filter",
+	"gmail_set_vacation",
+	"gmail_cache_purge",
+	"gmail_save_attachment",
+}
+
+// confirmTTL is how long a pending action can be confirmed.
+var confirmTTL = 10 * time.Minute
+
+// pendingAction is a gated tool call waiting for confirm_action.
+type pendingAction struct {
+	tool      string
+	user      string
+	request   mcp.CallToolRequest
+	handler   server.ToolHandlerFunc
+	preview   string
+	expiresAt time.Time
+}
+
+var (
+	pendingMu      sync.Mutex
+	pendingActions = make(map[string]*pendingAction)
+)
+
+// requireConfirmation defers handler: a call only records the request and
+// returns a token, and handler runs once confirm_action is called with it
+// by the same user before the token expires.
+func requireConfirmation(toolName string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		// Asking is pointless when the send policy refuses the call anyway.
+		outgoing := resolveOutgoing(ctx, toolName, request)
+		if err := checkSendPolicy(ctx, toolName, outgoing); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("%s not run: %v", toolName, err)), nil
+		}
+		token, err := newConfirmToken()
+		if err != nil {
+			return nil, err
+		}
+		action := &pendingAction{
+			tool:      toolName,
+			user:      session.UserID(ctx),
+			request:   request,
+			handler:   handler,
+			preview:   previewOutgoing(toolName, outgoing) + previewArguments(request.Params.Arguments),
+			expiresAt: time.Now().Add(confirmTTL),
+		}
+
+		pendingMu.Lock()
+		dropExpiredActions(time.Now())
+		pendingActions[token] = action
+		pendingMu.Unlock()
+
+		audit("requested", action, token, "")
+		return mcp.NewToolResultText(fmt.Sprintf(
+			"Confirmation required: %s has not run yet.\n\n%s\n"+
+				"Show this to the user. If they approve, call confirm_action with token %s before %s; "+
+				"otherwise call confirm_action with cancel set to true.",
+			toolName, action.preview, token, action.expiresAt.Format(time.RFC3339))), nil
+	}
+}
+
+// RegisterConfirmTools adds confirm_action, which runs or cancels calls
+// deferred by requireConfirmation.
+func RegisterConfirmTools(s ToolAdder) {
+	confirmTool := mcp.NewTool("confirm_action",
+		mcp.WithDescription("Run an action that required confirmation, after the user approved its preview. "+
+			"Only call this when the user explicitly agreed"),
+		mcp.WithString("token", mcp.Required(), mcp.Description("Token returned by the tool that required confirmation")),
+		mcp.WithBoolean("cancel", mcp.Description("Discard the action instead of running it")),
+	)
+	s.AddTool(confirmTool, utils.ErrorGuard(confirmActionHandler))
+}
+
+func confirmActionHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	token, ok := request.Params.Arguments["token"].(string)
+	if !ok || token == "" {
+		return mcp.NewToolResultError("token must be a non-empty string"), nil
+	}
+	cancel, _ := request.Params.Arguments["cancel"].(bool)
+	user := session.UserID(ctx)
+
+	pendingMu.Lock()
+	dropExpiredActions(time.Now())
+	action, ok := pendingActions[token]
+	// Tokens are single use, and only the user who started the action may
+	// confirm it.
+	if ok && action.user == user {
+		delete(pendingActions, token)
+	}
+	pendingMu.Unlock()
+
+	if ok && action.user != user {
+		audit("denied", action, token, user)
+	}
+	if !ok || action.user != user {
+		return mcp.NewToolResultError("unknown or expired confirmation token"), nil
+	}
+	if cancel {
+		audit("cancelled", action, token, user)
+		return mcp.NewToolResultText(fmt.Sprintf("%s cancelled.", action.tool)), nil
+	}
+	audit("confirmed", action, token, user)
+	return action.handler(ctx, action.request)
+}
+
+// dropExpiredActions must be called with pendingMu held.
+func dropExpiredActions(now time.Time) {
+	for token, action := range pendingActions {
+		if now.After(action.expiresAt) {
+			delete(pendingActions, token)
+			audit("expired", action, token, "")
+		}
+	}
+}
+
+func newConfirmToken() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// previewOutgoing shows the final recipients and subject of the mail a
+// call sends, which for replies, forwards and drafts the arguments do not
+// tell. Of several messages only the first is shown.
+func previewOutgoing(tool string, messages []*services.Email) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	switch {
+	case tool == "gmail_create_filter":
+		b.WriteString("Forwards matching mail to:\n")
+	case len(messages) == 1:
+		b.WriteString("Sends:\n")
+	default:
+		b.WriteString(fmt.Sprintf("Sends %d emails, the first:\n", len(messages)))
+	}
+	email := messages[0]
+	for _, field := range []struct {
+		name  string
+		value []string
+	}{{"To", email.To}, {"Cc", email.Cc}, {"Bcc", email.Bcc}} {
+		if len(field.value) > 0 {
+			b.WriteString(fmt.Sprintf("  %s: %s\n", field.name, strings.Join(field.value, ", ")))
+		}
+	}
+	if email.Subject != "" {
+		b.WriteString(fmt.Sprintf("  Subject: %s\n", previewValue(email.Subject)))
+	}
+	b.WriteString("\nArguments:\n")
+	return b.String()
+}
+
+// previewArguments lists the arguments of a call for a person to check.
+// Long values are shortened and inline file data is only described.
+func previewArguments(args map[string]interface{}) string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(fmt.Sprintf("%s: %s\n", name, previewValue(args[name])))
+	}
+	return b.String()
+}
+
+func previewValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		const limit = 2000
+		if len(v) > limit {
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(v[cut]) {
+				cut--
+			}
+			return v[:cut] + fmt.Sprintf("... (%d more bytes)", len(v)-cut)
+		}
+		return v
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = previewValue(item)
+		}
+		return "[" + strings.Join(items, "; ") + "]"
+	case map[string]interface{}:
+		var parts []string
+		for _, key := range sortedKeys(v) {
+			value := previewValue(v[key])
+			if data, ok := v[key].(string); ok && key == "data_base64" {
+				value = fmt.Sprintf("<%d bytes of base64>", len(data))
+			}
+			parts = append(parts, key+"="+value)
+		}
+		return strings.Join(parts, ", ")
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// auditMu serializes writes to CONFIRM_AUDIT_FILE.
+var auditMu sync.Mutex
+
+// audit records what happened to a pending action and, for confirmations,
+// who confirmed it. Entries go to the log and, as JSON lines, to
+// CONFIRM_AUDIT_FILE when set.
+func audit(event string, action *pendingAction, token, by string) {
+	entry := map[string]interface{}{
+		"time":      time.Now().UTC().Format(time.RFC3339),
+		"event":     event,
+		"tool":      action.tool,
+		"requester": action.user,
+		"token":     token[:8],
+	}
+	if by != "" {
+		entry["by"] = by
+	}
+	if event == "requested" || event == "confirmed" {
+		entry["arguments"] = action.preview
+	}
+	log.Printf("confirmation %s: tool=%s requester=%s by=%s", event, action.tool, action.user, by)
+
+	path := os.Getenv("CONFIRM_AUDIT_FILE")
+	if path == "" {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("failed to write confirmation audit: %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(b, '\n'))
+}
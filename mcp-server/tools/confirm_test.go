@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+)
+
+func TestPreviewValueCutsOnRunes(t *testing.T) {
+	for _, s := range []string{strings.Repeat("é", 1500), "x" + strings.Repeat("日本", 800)} {
+		got := previewValue(s)
+		if !utf8.ValidString(got) {
+			t.Errorf("preview of %d bytes is not valid UTF-8", len(s))
+		}
+		if !strings.Contains(got, "more bytes)") {
+			t.Errorf("preview of %d bytes was not shortened", len(s))
+		}
+	}
+}
+
+func TestPreviewOutgoing(t *testing.T) {
+	reply := []*services.Email{{To: []string{"alice@example.com"}, Cc: []string{"bob@example.com"}, Subject: "Re: Lunch"}}
+	got := previewOutgoing("gmail_reply", reply)
+	for _, want := range []string{"To: alice@example.com", "Cc: bob@example.com", "Subject: Re: Lunch"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("reply preview %q lacks %q", got, want)
+		}
+	}
+	if strings.Contains(got, "Bcc") {
+		t.Errorf("reply preview %q shows an empty Bcc", got)
+	}
+	merge := append(reply, &services.Email{To: []string{"carol@example.com"}})
+	if got := previewOutgoing("gmail_mail_merge", merge); !strings.Contains(got, "2 emails") {
+		t.Errorf("merge preview %q does not count the emails", got)
+	}
+	if got := previewOutgoing("gmail_trash", nil); got != "" {
+		t.Errorf("preview without mail = %q, want nothing", got)
+	}
+}
+
+func TestDraftHeaders(t *testing.T) {
+	raw := "From: me@example.com\r\n" +
+		"To: \"Alice\" <alice@example.com>, bob@example.com\r\n" +
+		"Bcc: carol@example.com\r\n" +
+		"Subject: =?UTF-8?B?w4lwaXNvZGUgMg==?=\r\n" +
+		"\r\nHello"
+	got, err := draftHeaders([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTo := []string{`"Alice" <alice@example.com>`, "<bob@example.com>"}
+	if !reflect.DeepEqual(got.To, wantTo) || len(got.Cc) != 0 || !reflect.DeepEqual(got.Bcc, []string{"<carol@example.com>"}) {
+		t.Errorf("draftHeaders recipients = %q, %q, %q", got.To, got.Cc, got.Bcc)
+	}
+	if got.Subject != "Épisode 2" {
+		t.Errorf("draftHeaders subject = %q, want the decoded subject", got.Subject)
+	}
+}
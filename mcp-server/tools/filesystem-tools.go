@@ -1,9 +1,5 @@
 package tools
 
-import (
-	"github.com/mark3labs/mcp-go/server"
-)
-
-func RegisterFilesystemTools(s *server.MCPServer) {
+func RegisterFilesystemTools(s ToolAdder) {
 
 }
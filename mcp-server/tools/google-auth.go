@@ -0,0 +1,367 @@
+package tools
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// SharedGoogleToken lets callers without their own google_token fall back to
+// the server-wide token file. It is meant for single-user (stdio) setups; on
+// shared deployments every caller would otherwise act as the same account.
+var SharedGoogleToken = true
+
+// toolScopeProfiles names the Google scope profiles each tool needs. Only
+// tools listed here count towards the scopes requested at login.
+var toolScopeProfiles = map[string][]string{
+	"gmail_search":          {services.ProfileGmailReadonly},
+	"gmail_read_email":      {services.ProfileGmailReadonly},
+	"gmail_get_attachment":  {services.ProfileGmailReadonly},
+	"gmail_save_attachment": {services.ProfileGmailReadonly},
+	"gmail_list_threads":    {services.ProfileGmailReadonly},
+	"gmail_changes_since":   {services.ProfileGmailReadonly},
+	"gmail_cache_stats":     {services.ProfileGmailReadonly},
+	"gmail_cache_purge":     {services.ProfileGmailReadonly},
+	"gmail_read_thread":     {services.ProfileGmailReadonly},
+	"gmail_mark_read":       {services.ProfileGmailModify},
+	"gmail_list_labels":     {services.ProfileGmailReadonly},
+	"gmail_create_label":    {services.ProfileGmailModify},
+	"gmail_modify_labels":   {services.ProfileGmailModify},
+	"gmail_archive":         {services.ProfileGmailModify},
+	"gmail_trash":           {services.ProfileGmailModify},
+	"gmail_untrash":         {services.ProfileGmailModify},
+	"gmail_star":            {services.ProfileGmailModify},
+	"gmail_mark_unread":     {services.ProfileGmailModify},
+	"gmail_mark_spam":       {services.ProfileGmailModify},
+	"gmail_mail_merge":      {services.ProfileGmailCompose},
+	"gmail_list_filters":    {services.ProfileGmailReadonly},
+	"gmail_create_filter":   {services.ProfileGmailSettings},
+	"gmail_delete_filter":   {services.ProfileGmailSettings},
+	"gmail_get_vacation":    {services.ProfileGmailReadonly},
+	"gmail_set_vacation":    {services.ProfileGmailSettings},
+	"gmail_list_send_as":    {services.ProfileGmailReadonly},
+	"gmail_send_email":      {services.ProfileGmailSend},
+	"gmail_reply":           {services.ProfileGmailReadonly, services.ProfileGmailSend},
+	"gmail_forward":         {services.ProfileGmailReadonly, services.ProfileGmailSend},
+	"gmail_create_draft":    {services.ProfileGmailCompose},
+	"gmail_update_draft":    {services.ProfileGmailCompose},
+	"gmail_list_drafts":     {services.ProfileGmailCompose},
+	"gmail_send_draft":      {services.ProfileGmailCompose},
+	"gmail_delete_draft":    {services.ProfileGmailCompose},
+	"google_auth_status":    {services.ProfileGmailReadonly},
+}
+
+// gmailClients caches per-user Gmail services built from session tokens.
+var gmailClients = services.NewClientCache[*gmail.Service](256, 30*time.Minute)
+
+// googleCredentialFiles returns the OAuth client credentials and the
+// server-wide token file of the GOOGLE_ACCOUNT account.
+func googleCredentialFiles() (credentialsFile, tokenFile string) {
+	paths := services.GooglePathsFromEnv()
+	return paths.CredentialsFile, paths.TokenFile(os.Getenv("GOOGLE_ACCOUNT"))
+}
+
+// sharedClientKey caches the client built from the server-wide token file.
+// It cannot collide with a user ID.
+const sharedClientKey = "\x00shared"
+
+// delegatedKeyPrefix prefixes the cache key of clients acting through the
+// service account, which are shared by every caller impersonating the same user.
+const delegatedKeyPrefix = "\x00delegated:"
+
+// delegatedSubject returns the user the caller asked the service account to
+// act as with the google_account setting, or "" when they did not.
+func delegatedSubject(ctx context.Context) (string, error) {
+	subject, err := session.Get(ctx, session.ServiceGoogleAccount)
+	if errors.Is(err, session.ErrNotFound) {
+		return "", nil
+	}
+	return subject, err
+}
+
+// mailbox is the address of the mailbox a Gmail client works on. It holds
+// on to the client so its address cannot be reused while cached.
+type mailbox struct {
+	srv     *gmail.Service
+	address string
+}
+
+// mailboxes caches the mailbox of each Gmail client.
+var mailboxes = services.NewClientCache[mailbox](256, 30*time.Minute)
+
+// mailboxFor returns the address of the mailbox the caller works on: the
+// user the service account acts as, or the owner of their token. Unlike the
+// session user ID, it stays the same across reconnects and changes with
+// google_account or google_token.
+func mailboxFor(ctx context.Context, srv *gmail.Service) (string, error) {
+	subject, err := delegatedSubject(ctx)
+	if err != nil || subject != "" {
+		return strings.ToLower(subject), err
+	}
+	box, err := mailboxes.Get(fmt.Sprintf("%p", srv), "", func() (mailbox, error) {
+		profile, err := srv.Users.GetProfile("me").Context(ctx).Do()
+		if err != nil {
+			return mailbox{}, err
+		}
+		return mailbox{srv: srv, address: strings.ToLower(profile.EmailAddress)}, nil
+	})
+	return box.address, err
+}
+
+// googleTokenStore resolves where the caller's Google token lives: the
+// google_token they set with set_api_key, else the server-wide token file when
+// SharedGoogleToken allows it. cacheKey identifies whose token it is.
+func googleTokenStore(ctx context.Context) (store services.TokenStore, cacheKey string, err error) {
+	_, err = session.Get(ctx, session.ServiceGoogleToken)
+	if errors.Is(err, session.ErrNotFound) {
+		if !SharedGoogleToken {
+			return nil, "", fmt.Errorf("%w: no Google account linked to this session", services.ErrMissingToken)
+		}
+		_, tokenFile := googleCredentialFiles()
+		return services.FileTokenStore{Path: tokenFile}, sharedClientKey, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	user := session.UserID(ctx)
+	return sessionTokenStore{user: user}, user, nil
+}
+
+// sessionTokenStore keeps a user's token as the google_token credential.
+type sessionTokenStore struct {
+	user string
+}
+
+func (s sessionTokenStore) Load() (*oauth2.Token, error) {
+	tokenJSON, err := session.Get(session.WithUser(context.Background(), s.user), session.ServiceGoogleToken)
+	if errors.Is(err, session.ErrNotFound) {
+		return nil, fmt.Errorf("%w: no google_token set", services.ErrMissingToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tok, err := services.DecodeToken([]byte(tokenJSON))
+	if err != nil {
+		return nil, fmt.Errorf("%w: google_token is not valid token JSON: %v", services.ErrMissingToken, err)
+	}
+	return tok, nil
+}
+
+func (s sessionTokenStore) Save(tok *oauth2.Token) error {
+	b, err := services.EncodeToken(tok)
+	if err != nil {
+		return err
+	}
+	return session.Replace(session.WithUser(context.Background(), s.user), session.ServiceGoogleToken, string(b))
+}
+
+// gmailServiceFor returns the Gmail client for the caller. Clients are cached
+// per user and rebuilt when the token is replaced; refreshed tokens are
+// written back to where they came from.
+func gmailServiceFor(ctx context.Context) (*gmail.Service, error) {
+	subject, err := delegatedSubject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if subject != "" {
+		return gmailClients.Get(delegatedKeyPrefix+subject, "", func() (*gmail.Service, error) {
+			client, err := services.DelegationFromEnv().Client(subject)
+			if err != nil {
+				return nil, err
+			}
+			return gmail.NewService(context.Background(), option.WithHTTPClient(client))
+		})
+	}
+
+	store, cacheKey, err := googleTokenStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return gmailClients.Get(cacheKey, tokenFingerprint(tok), func() (*gmail.Service, error) {
+		credentialsFile, _ := googleCredentialFiles()
+		client, err := services.GoogleStoreClient(store, credentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		return gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	})
+}
+
+// requireScopes fails a call early, with instructions, when the caller's
+// token is known to lack the scopes of profiles. Tokens that do not record
+// their scopes, and service account access, are let through and rely on
+// Google's own error.
+func requireScopes(toolName string, profiles []string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if subject, _ := delegatedSubject(ctx); subject != "" {
+			return handler(ctx, request)
+		}
+		store, _, err := googleTokenStore(ctx)
+		if err != nil {
+			return handler(ctx, request)
+		}
+		tok, err := store.Load()
+		if err != nil {
+			return handler(ctx, request)
+		}
+		granted := services.TokenScopes(tok)
+		if len(granted) == 0 {
+			return handler(ctx, request)
+		}
+		for _, profile := range profiles {
+			if missing := services.MissingScopes(granted, profile); len(missing) > 0 {
+				return mcp.NewToolResultError(fmt.Sprintf(
+					"%s needs the %s scope profile, but the Google token was not granted: %s\n"+
+						"Sign in again with `server auth login`, which requests the scopes of every enabled tool.",
+					toolName, profile, strings.Join(missing, ", "))), nil
+			}
+		}
+		return handler(ctx, request)
+	}
+}
+
+// googleErrorResult turns a failed Google call into a tool error that says
+// what went wrong and, for credential problems, how to fix it.
+func googleErrorResult(action string, err error) *mcp.CallToolResult {
+	err = services.ClassifyGoogleError(err)
+	msg := fmt.Sprintf("failed to %s: %v", action, err)
+	if hint := services.GoogleErrorHint(err); hint != "" {
+		msg += "\n" + hint
+	}
+	return mcp.NewToolResultError(msg)
+}
+
+func googleAuthStatusHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	subject, err := delegatedSubject(ctx)
+	if err != nil {
+		return googleErrorResult("resolve Google credentials", err), nil
+	}
+	if subject != "" {
+		return delegatedAuthStatus(ctx, subject, format)
+	}
+
+	store, cacheKey, err := googleTokenStore(ctx)
+	if err != nil {
+		return googleErrorResult("resolve Google credentials", err), nil
+	}
+
+	credentialsFile, _ := googleCredentialFiles()
+	info, err := services.GoogleTokenInfo(ctx, store, credentialsFile)
+	if err != nil {
+		return googleErrorResult("inspect Google token", err), nil
+	}
+
+	account := info.Email
+	if account == "" {
+		if srv, err := gmailServiceFor(ctx); err == nil {
+			if profile, err := srv.Users.GetProfile("me").Context(ctx).Do(); err == nil {
+				account = profile.EmailAddress
+			}
+		}
+	}
+
+	source := "session google_token"
+	if cacheKey == sharedClientKey {
+		source = "server-wide token file"
+	}
+
+	return structuredResult("google_auth_status", format, authStatus{
+		Account:         account,
+		TokenSource:     source,
+		Expiry:          info.Expiry,
+		HasRefreshToken: info.HasRefreshToken,
+		Scopes:          nonNil(info.Scopes),
+	}), nil
+}
+
+// authStatus is the result of google_auth_status.
+type authStatus struct {
+	Account         string    `json:"account" desc:"Email address of the Google account"`
+	TokenSource     string    `json:"token_source"`
+	Expiry          time.Time `json:"expiry" desc:"When the current access token expires"`
+	HasRefreshToken bool      `json:"has_refresh_token"`
+	Scopes          []string  `json:"scopes"`
+}
+
+func (a authStatus) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Account: %s\n", a.Account))
+	result.WriteString(fmt.Sprintf("Token source: %s\n", a.TokenSource))
+	result.WriteString(fmt.Sprintf("Access token expires: %s\n", a.Expiry.Format(time.RFC3339)))
+	result.WriteString(fmt.Sprintf("Refresh token: %t\n", a.HasRefreshToken))
+	result.WriteString("Scopes:\n")
+	for _, scope := range a.Scopes {
+		result.WriteString(fmt.Sprintf("- %s\n", scope))
+	}
+	return result.String()
+}
+
+func (a authStatus) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("- **Account:** %s\n", a.Account))
+	result.WriteString(fmt.Sprintf("- **Token source:** %s\n", a.TokenSource))
+	result.WriteString(fmt.Sprintf("- **Access token expires:** %s\n", a.Expiry.Format(time.RFC3339)))
+	result.WriteString(fmt.Sprintf("- **Refresh token:** %t\n", a.HasRefreshToken))
+	result.WriteString("- **Scopes:**\n")
+	for _, scope := range a.Scopes {
+		result.WriteString(fmt.Sprintf("  - `%s`\n", scope))
+	}
+	return result.String()
+}
+
+// delegatedAuthStatus reports on the service account acting as subject.
+func delegatedAuthStatus(ctx context.Context, subject, format string) (*mcp.CallToolResult, error) {
+	config, err := services.DelegationFromEnv().Config(subject)
+	if err != nil {
+		return googleErrorResult("configure service account", err), nil
+	}
+	tok, err := config.TokenSource(ctx).Token()
+	if err != nil {
+		return googleErrorResult("impersonate "+subject, services.ClassifyGoogleError(err)), nil
+	}
+
+	return structuredResult("google_auth_status", format, authStatus{
+		Account:     subject,
+		TokenSource: fmt.Sprintf("service account %s (domain-wide delegation)", config.Email),
+		Expiry:      tok.Expiry,
+		Scopes:      nonNil(config.Scopes),
+	}), nil
+}
+
+// tokenFingerprint identifies the grant a token belongs to, so refreshing
+// the access token does not invalidate a cached client.
+func tokenFingerprint(tok *oauth2.Token) string {
+	secret := tok.RefreshToken
+	if secret == "" {
+		secret = tok.AccessToken
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// forgetGoogleClient drops the cached Gmail client of the caller.
+func forgetGoogleClient(ctx context.Context) {
+	gmailClients.Evict(session.UserID(ctx))
+}
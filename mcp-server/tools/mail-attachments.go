@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Ways gmail_get_attachment can return an attachment.
+const (
+	attachmentModeText   = "text"
+	attachmentModeBase64 = "base64"
+)
+
+const (
+	// maxAttachmentText bounds the extracted text returned in one result.
+	maxAttachmentText = 200_000
+	// maxAttachmentBase64 bounds attachments returned inline; larger ones
+	// have to be saved with gmail_save_attachment.
+	maxAttachmentBase64 = 10 << 20
+)
+
+type attachmentContent struct {
+	MessageID  string         `json:"message_id"`
+	Attachment attachmentInfo `json:"attachment"`
+	Mode       string         `json:"mode" desc:"text or base64"`
+	Content    string         `json:"text,omitempty" desc:"Extracted text (mode text)"`
+	Truncated  bool           `json:"truncated,omitempty" desc:"Set when the text was cut short"`
+	DataBase64 string         `json:"data_base64,omitempty" desc:"Content, standard base64 (mode base64)"`
+}
+
+type savedAttachment struct {
+	MessageID  string         `json:"message_id"`
+	Attachment attachmentInfo `json:"attachment"`
+	SavedPath  string         `json:"saved_path" desc:"Path relative to ATTACHMENT_DIR"`
+}
+
+// attachmentParams identify the attachment gmail_get_attachment and
+// gmail_save_attachment work on.
+var attachmentParams = []mcp.ToolOption{
+	mcp.WithString("message_id", mcp.Required(), mcp.Description("ID of the email the attachment belongs to")),
+	mcp.WithString("part_id", mcp.Description("MIME part ID of the attachment, e.g. \"1.2\"")),
+	mcp.WithString("filename", mcp.Description("File name of the attachment, when part_id is not given")),
+	outputFormatParam,
+}
+
+func registerAttachmentTools(s ToolAdder) {
+	// Get attachment tool
+	getAttachmentTool := mcp.NewTool("gmail_get_attachment", append([]mcp.ToolOption{
+		mcp.WithDescription("Fetch an attachment of an email, including attachments nested in forwarded messages. " +
+			"Returns its extracted text (txt, csv, html, json, eml and the text layer of PDFs) or its base64 content. " +
+			"Identify it by part_id or filename as listed by gmail_read_email with include_attachments"),
+		readOnlyAnnotation,
+		mcp.WithString("mode",
+			mcp.Description("text (default) extracts readable text, base64 returns the raw content"),
+			mcp.Enum(attachmentModeText, attachmentModeBase64),
+		),
+	}, attachmentParams...)...)
+	s.AddTool(getAttachmentTool, utils.ErrorGuard(gmailGetAttachmentHandler))
+
+	// Save attachment tool
+	saveAttachmentTool := mcp.NewTool("gmail_save_attachment", append([]mcp.ToolOption{
+		mcp.WithDescription("Save an attachment of an email to ATTACHMENT_DIR on the server, without overwriting existing files. " +
+			"Identify it by part_id or filename as listed by gmail_read_email with include_attachments"),
+	}, attachmentParams...)...)
+	s.AddTool(saveAttachmentTool, utils.ErrorGuard(gmailSaveAttachmentHandler))
+}
+
+// fetchAttachment downloads the attachment a request names. A non-nil
+// result reports why it could not.
+func fetchAttachment(ctx context.Context, request mcp.CallToolRequest) (*gmail.Message, *gmail.MessagePart, []byte, *mcp.CallToolResult) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return nil, nil, nil, googleErrorResult("create Gmail client", err)
+	}
+
+	messageID, ok := request.Params.Arguments["message_id"].(string)
+	if !ok || messageID == "" {
+		return nil, nil, nil, mcp.NewToolResultError("message_id must be a non-empty string")
+	}
+	partID, _ := request.Params.Arguments["part_id"].(string)
+	filename, _ := request.Params.Arguments["filename"].(string)
+	if partID == "" && filename == "" {
+		return nil, nil, nil, mcp.NewToolResultError("part_id or filename is required")
+	}
+
+	message, err := srv.Users.Messages.Get("me", messageID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return nil, nil, nil, googleErrorResult("get email", err)
+	}
+	var part *gmail.MessagePart
+	for _, p := range attachmentParts(message.Payload) {
+		if (partID != "" && p.PartId == partID) || (partID == "" && p.Filename == filename) {
+			part = p
+			break
+		}
+	}
+	if part == nil {
+		return nil, nil, nil, mcp.NewToolResultError("no such attachment; list them with gmail_read_email and include_attachments")
+	}
+	data, err := attachmentData(ctx, srv, message.Id, part)
+	if err != nil {
+		return nil, nil, nil, googleErrorResult("download attachment", err)
+	}
+	return message, part, data, nil
+}
+
+func gmailGetAttachmentHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	mode, _ := request.Params.Arguments["mode"].(string)
+	if mode == "" {
+		mode = attachmentModeText
+	}
+	if mode != attachmentModeText && mode != attachmentModeBase64 {
+		return mcp.NewToolResultError("mode must be text or base64"), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	message, part, data, failed := fetchAttachment(ctx, request)
+	if failed != nil {
+		return failed, nil
+	}
+
+	out := attachmentContent{MessageID: message.Id, Attachment: newAttachmentInfo(part), Mode: mode}
+	out.Attachment.Size = int64(len(data))
+	switch mode {
+	case attachmentModeText:
+		text, err := services.ExtractText(part.Filename, part.MimeType, data)
+		if err != nil {
+			if errors.Is(err, services.ErrUnsupportedContent) {
+				return mcp.NewToolResultError(err.Error() + "; use mode base64 or gmail_save_attachment instead"), nil
+			}
+			return mcp.NewToolResultError(fmt.Sprintf("failed to extract text from %s: %v", part.Filename, err)), nil
+		}
+		if len(text) > maxAttachmentText {
+			text = strings.ToValidUTF8(text[:maxAttachmentText], "")
+			out.Truncated = true
+		}
+		out.Content = text
+	case attachmentModeBase64:
+		if len(data) > maxAttachmentBase64 {
+			return mcp.NewToolResultError(fmt.Sprintf("attachment is larger than %d MB; use gmail_save_attachment instead", maxAttachmentBase64>>20)), nil
+		}
+		out.DataBase64 = base64.StdEncoding.EncodeToString(data)
+	}
+	return structuredResult("gmail_get_attachment", format, out), nil
+}
+
+func gmailSaveAttachmentHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	message, part, data, failed := fetchAttachment(ctx, request)
+	if failed != nil {
+		return failed, nil
+	}
+
+	out := savedAttachment{MessageID: message.Id, Attachment: newAttachmentInfo(part)}
+	out.Attachment.Size = int64(len(data))
+	out.SavedPath, err = saveAttachmentFile(part.Filename, data)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to save attachment: %v", err)), nil
+	}
+	return structuredResult("gmail_save_attachment", format, out), nil
+}
+
+// attachmentParts returns every part of the MIME tree that is an attachment,
+// depth first, so attachments of attached messages are included.
+func attachmentParts(part *gmail.MessagePart) []*gmail.MessagePart {
+	if part == nil {
+		return nil
+	}
+	var parts []*gmail.MessagePart
+	if part.Filename != "" && part.Body != nil {
+		parts = append(parts, part)
+	}
+	for _, child := range part.Parts {
+		parts = append(parts, attachmentParts(child)...)
+	}
+	return parts
+}
+
+// attachmentData returns the decoded content of part, downloading it when
+// Gmail did not include it in the message.
+func attachmentData(ctx context.Context, srv *gmail.Service, messageID string, part *gmail.MessagePart) ([]byte, error) {
+	data := part.Body.Data
+	if part.Body.AttachmentId != "" {
+		body, err := srv.Users.Messages.Attachments.Get("me", messageID, part.Body.AttachmentId).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		data = body.Data
+	}
+	decoded, err := decodeBodyData(data)
+	if err != nil {
+		return nil, fmt.Errorf("attachment %s: %w", part.Filename, err)
+	}
+	return decoded, nil
+}
+
+func newAttachmentInfo(part *gmail.MessagePart) attachmentInfo {
+	return attachmentInfo{
+		PartID:       part.PartId,
+		Filename:     part.Filename,
+		MimeType:     part.MimeType,
+		Size:         part.Body.Size,
+		AttachmentID: part.Body.AttachmentId,
+	}
+}
+
+// saveAttachmentFile writes data to ATTACHMENT_DIR under filename, adding a
+// number when the name is taken, and returns the path relative to it.
+func saveAttachmentFile(filename string, data []byte) (string, error) {
+	dir := os.Getenv("ATTACHMENT_DIR")
+	if dir == "" {
+		return "", errors.New("saving attachments is disabled; set ATTACHMENT_DIR")
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("ATTACHMENT_DIR: %w", err)
+	}
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(filename, `\`, "/")))
+	if name == "/" || name == "." || name == ".." {
+		name = "attachment"
+	}
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		}
+		// O_EXCL never follows or replaces an existing file or symlink.
+		f, err := os.OpenFile(filepath.Join(root, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return "", err
+		}
+		return candidate, f.Close()
+	}
+	return "", fmt.Errorf("too many files named %s in ATTACHMENT_DIR", name)
+}
+
+func (r attachmentContent) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Attachment: %s (%s, %d bytes, part %s)\n", r.Attachment.Filename, r.Attachment.MimeType, r.Attachment.Size, r.Attachment.PartID))
+	switch r.Mode {
+	case attachmentModeText:
+		result.WriteString("\n" + r.Content + "\n")
+		if r.Truncated {
+			result.WriteString(fmt.Sprintf("\n[Text truncated after %d bytes]\n", maxAttachmentText))
+		}
+	case attachmentModeBase64:
+		result.WriteString("Content (base64):\n" + r.DataBase64 + "\n")
+	}
+	return result.String()
+}
+
+func (r attachmentContent) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**%s** (%s, %d bytes, part `%s`)\n\n", r.Attachment.Filename, r.Attachment.MimeType, r.Attachment.Size, r.Attachment.PartID))
+	switch r.Mode {
+	case attachmentModeText:
+		result.WriteString("```\n" + r.Content + "\n```\n")
+		if r.Truncated {
+			result.WriteString(fmt.Sprintf("\n_Text truncated after %d bytes._\n", maxAttachmentText))
+		}
+	case attachmentModeBase64:
+		result.WriteString("```\n" + r.DataBase64 + "\n```\n")
+	}
+	return result.String()
+}
+
+func (r savedAttachment) Text() string {
+	return fmt.Sprintf("Attachment %s (%s, %d bytes, part %s) saved to %s in ATTACHMENT_DIR\n",
+		r.Attachment.Filename, r.Attachment.MimeType, r.Attachment.Size, r.Attachment.PartID, r.SavedPath)
+}
+
+func (r savedAttachment) Markdown() string {
+	return fmt.Sprintf("**%s** (%s, %d bytes, part `%s`) saved to `%s` in ATTACHMENT_DIR.\n",
+		r.Attachment.Filename, r.Attachment.MimeType, r.Attachment.Size, r.Attachment.PartID, r.SavedPath)
+}
@@ -0,0 +1,492 @@
+package tools
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// defaultCacheMessages bounds the first fill of a mail cache.
+	defaultCacheMessages = 2000
+	// cacheSyncInterval is how long a synced cache answers searches before
+	// it asks Gmail for changes again.
+	cacheSyncInterval = 30 * time.Second
+	// maxCachedBody is how much of a body is kept for the full-text index.
+	maxCachedBody = 64 << 10
+	// cachePageTokenPrefix marks page tokens of searches answered from the
+	// cache, which hold the offset of the next page.
+	cachePageTokenPrefix = "cache:"
+)
+
+var errCacheDisabled = errors.New("the mail cache is off; set MAIL_CACHE_DIR to enable it")
+
+// mailCache is the store of one mailbox. syncMu serialises syncs and
+// purges; sessions, guarded by mailCachesMu, are the client sessions using it.
+type mailCache struct {
+	syncMu   sync.Mutex
+	store    *services.MailStore
+	sessions map[string]bool
+}
+
+// Open mail caches by directory. A cache is closed once no client session
+// uses it any more.
+var (
+	mailCachesMu sync.Mutex
+	mailCaches   = make(map[string]*mailCache)
+)
+
+type cacheStats struct {
+	Messages  int    `json:"messages"`
+	Terms     int    `json:"terms" desc:"Distinct words in the full-text index"`
+	DiskBytes int64  `json:"disk_bytes"`
+	Oldest    string `json:"oldest,omitempty" desc:"Date of the oldest cached email, RFC 3339"`
+	Newest    string `json:"newest,omitempty" desc:"Date of the newest cached email, RFC 3339"`
+	Since     string `json:"since,omitempty" desc:"Set when the cache holds only the emails from this date on, RFC 3339; searches reaching further back go to Gmail"`
+	HistoryID string `json:"history_id,omitempty" desc:"Gmail history ID the cache is synced to"`
+	LastSync  string `json:"last_sync,omitempty" desc:"RFC 3339"`
+}
+
+type cachePurgeResult struct {
+	Messages int `json:"messages" desc:"Number of cached emails deleted"`
+}
+
+// mailCacheFor returns the cache of the mailbox srv works on, or
+// errCacheDisabled when MAIL_CACHE_DIR is unset. Each mailbox gets a
+// directory named after a hash of its address, whichever session or token
+// reaches it.
+func mailCacheFor(ctx context.Context, srv *gmail.Service) (*mailCache, error) {
+	root := os.Getenv("MAIL_CACHE_DIR")
+	if root == "" {
+		return nil, errCacheDisabled
+	}
+	keys, err := mailCacheKeys()
+	if err != nil {
+		return nil, err
+	}
+	account, err := mailboxFor(ctx, srv)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(account))
+	dir := filepath.Join(root, hex.EncodeToString(sum[:8]))
+
+	mailCachesMu.Lock()
+	defer mailCachesMu.Unlock()
+	c, ok := mailCaches[dir]
+	if !ok {
+		store, err := services.OpenMailStore(dir, keys)
+		if err != nil {
+			return nil, err
+		}
+		c = &mailCache{store: store, sessions: make(map[string]bool)}
+		mailCaches[dir] = c
+	}
+	c.sessions[clientSessionKey(ctx)] = true
+	return c, nil
+}
+
+// ForgetMailCache closes the mail caches only the ending client session
+// used. Their files stay for the next session of the same mailbox.
+func ForgetMailCache(ctx context.Context, cs server.ClientSession) {
+	mailCachesMu.Lock()
+	defer mailCachesMu.Unlock()
+	for dir, c := range mailCaches {
+		delete(c.sessions, cs.SessionID())
+		if len(c.sessions) == 0 {
+			delete(mailCaches, dir)
+		}
+	}
+}
+
+// mailCacheKeys returns the keyring of the session file store, which also
+// seals the mail cache. MAIL_CACHE_PLAINTEXT=true stores it unencrypted
+// instead.
+func mailCacheKeys() (*session.Keyring, error) {
+	if plain, _ := strconv.ParseBool(os.Getenv("MAIL_CACHE_PLAINTEXT")); plain {
+		return nil, nil
+	}
+	keys, err := session.KeyringFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("the mail cache is encrypted: %w, or set MAIL_CACHE_PLAINTEXT=true", err)
+	}
+	return keys, nil
+}
+
+// cacheMessageLimit reads MAIL_CACHE_MAX_MESSAGES.
+func cacheMessageLimit() (int64, error) {
+	v := os.Getenv("MAIL_CACHE_MAX_MESSAGES")
+	if v == "" {
+		return defaultCacheMessages, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("MAIL_CACHE_MAX_MESSAGES must be a positive integer, got %q", v)
+	}
+	return n, nil
+}
+
+// sync brings the cache up to date with the mailbox through the history
+// API. An empty cache, or one whose history has expired, is refilled with
+// the newest MAIL_CACHE_MAX_MESSAGES messages. Caches synced within
+// cacheSyncInterval are left alone.
+func (c *mailCache) sync(ctx context.Context, srv *gmail.Service) error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	state := c.store.State()
+	if state.HistoryID != 0 && time.Since(state.LastSync) < cacheSyncInterval {
+		return nil
+	}
+
+	labels, err := srv.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	state.Labels = make(map[string]string, len(labels.Labels))
+	for _, label := range labels.Labels {
+		state.Labels[label.Id] = label.Name
+	}
+
+	if state.HistoryID != 0 {
+		historyID, err := c.applyHistory(ctx, srv, state.HistoryID)
+		switch {
+		case err == nil:
+			state.HistoryID = historyID
+		case errors.Is(err, errHistoryExpired):
+			log.Printf("Mail cache history expired, refilling: %v", err)
+			if err := c.store.Purge(); err != nil {
+				return err
+			}
+			state.HistoryID = 0
+		default:
+			return err
+		}
+	}
+	if state.HistoryID == 0 {
+		limit, err := cacheMessageLimit()
+		if err != nil {
+			return err
+		}
+		// Like fullSync, read the history ID first so no change is missed.
+		changes, err := fullSync(ctx, srv, "", limit)
+		if err != nil {
+			return err
+		}
+		if err := c.fetch(ctx, srv, changes.Added); err != nil {
+			return err
+		}
+		state.HistoryID = changes.HistoryID
+		state.Truncated, state.Since = changes.More, time.Time{}
+		if changes.More {
+			stats, err := c.store.Stats()
+			if err != nil {
+				return err
+			}
+			state.Since = stats.Oldest
+		}
+	}
+	state.LastSync = time.Now()
+	return c.store.SetState(state)
+}
+
+// applyHistory applies the changes after since and returns the history ID
+// it got to.
+func (c *mailCache) applyHistory(ctx context.Context, srv *gmail.Service, since uint64) (uint64, error) {
+	for {
+		changes, err := historyChanges(ctx, srv, since, "", maxHistoryChanges)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.store.Delete(changes.Deleted...); err != nil {
+			return 0, err
+		}
+		// Relabelled messages the cache does not hold are fetched whole.
+		missing := changes.Added
+		for _, change := range changes.LabelChanges {
+			stored, err := c.store.Relabel(change.MessageID, change.Added, change.Removed)
+			if err != nil {
+				return 0, err
+			}
+			if !stored {
+				missing = append(missing, change.MessageID)
+			}
+		}
+		if err := c.fetch(ctx, srv, missing); err != nil {
+			return 0, err
+		}
+		since = changes.HistoryID
+		if !changes.More {
+			return since, nil
+		}
+	}
+}
+
+// fetch downloads the messages with the given IDs and stores them.
+// Messages deleted in the meantime are skipped, but any other failure fails
+// the sync, so that the history ID is not moved past messages never stored.
+func (c *mailCache) fetch(ctx context.Context, srv *gmail.Service, ids []string) error {
+	var (
+		failedMu sync.Mutex
+		failed   error
+	)
+	messages, err := fetchConcurrently(ctx, ids, func(ctx context.Context, id string) (*gmail.Message, error) {
+		message, err := srv.Users.Messages.Get("me", id).Format("full").Context(ctx).Do()
+		var apiErr *googleapi.Error
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound) {
+			failedMu.Lock()
+			if failed == nil {
+				failed = fmt.Errorf("get email %s: %w", id, err)
+			}
+			failedMu.Unlock()
+		}
+		return message, err
+	})
+	if err == nil {
+		err = failed
+	}
+	if err != nil {
+		return err
+	}
+	cached := make([]services.CachedMessage, 0, len(messages))
+	for _, message := range messages {
+		cached = append(cached, newCachedMessage(message))
+	}
+	return c.store.Put(cached...)
+}
+
+func newCachedMessage(message *gmail.Message) services.CachedMessage {
+	text, html := messageBodies(message.Payload)
+	if strings.TrimSpace(text) == "" {
+		text = services.HTMLToText(html)
+	}
+	if len(text) > maxCachedBody {
+		text = strings.ToValidUTF8(text[:maxCachedBody], "")
+	}
+	return services.CachedMessage{
+		ID:            message.Id,
+		ThreadID:      message.ThreadId,
+		From:          headerValue(message.Payload, "From"),
+		To:            headerValue(message.Payload, "To"),
+		Cc:            headerValue(message.Payload, "Cc"),
+		Subject:       headerValue(message.Payload, "Subject"),
+		Date:          headerValue(message.Payload, "Date"),
+		Snippet:       message.Snippet,
+		Body:          text,
+		Labels:        nonNil(message.LabelIds),
+		InternalDate:  message.InternalDate,
+		HasAttachment: len(attachmentParts(message.Payload)) > 0,
+		Size:          message.SizeEstimate,
+	}
+}
+
+// searchMailCache answers a gmail_search from the mail cache after syncing
+// it. It reports false when the search must go to Gmail: the cache is off,
+// the query uses syntax the cache does not support or could match emails
+// older than a truncated cache holds, or the page token is Gmail's. When
+// the sync fails a cache that was filled before still answers, so searches
+// keep working offline.
+func searchMailCache(ctx context.Context, srv *gmail.Service, query string, maxResults int64, pageToken string) (searchResult, bool) {
+	offset := 0
+	if pageToken != "" {
+		v, ok := strings.CutPrefix(pageToken, cachePageTokenPrefix)
+		if !ok {
+			return searchResult{}, false
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return searchResult{}, false
+		}
+		offset = n
+	}
+	q, err := services.ParseMailQuery(query)
+	if err != nil {
+		return searchResult{}, false
+	}
+	c, err := mailCacheFor(ctx, srv)
+	if err != nil {
+		if !errors.Is(err, errCacheDisabled) {
+			log.Printf("Mail cache unavailable: %v", err)
+		}
+		return searchResult{}, false
+	}
+	var staleSince string
+	if err := c.sync(ctx, srv); err != nil {
+		if c.store.State().HistoryID == 0 {
+			log.Printf("Mail cache sync failed, searching Gmail: %v", err)
+			return searchResult{}, false
+		}
+		log.Printf("Mail cache sync failed, searching the cache as it is: %v", err)
+		staleSince = c.store.State().LastSync.UTC().Format(time.RFC3339)
+	}
+	// Without an after: date inside the cache, any page could run past the
+	// oldest cached email into mail only Gmail has.
+	if !c.store.State().Covers(q) {
+		return searchResult{}, false
+	}
+
+	messages, total := c.store.Search(q, offset, int(maxResults))
+	out := searchResult{Messages: []emailSummary{}, Cached: true, StaleSince: staleSince}
+	for _, m := range messages {
+		out.Messages = append(out.Messages, emailSummary{
+			ID:       m.ID,
+			ThreadID: m.ThreadID,
+			From:     m.From,
+			Subject:  m.Subject,
+			Date:     m.Date,
+			Snippet:  m.Snippet,
+			Labels:   nonNil(m.Labels),
+		})
+	}
+	if next := offset + len(messages); next < total {
+		out.NextPageToken = cachePageTokenPrefix + strconv.Itoa(next)
+	}
+	return out, true
+}
+
+func registerCacheTools(s ToolAdder) {
+	// Cache stats tool
+	statsTool := mcp.NewTool("gmail_cache_stats",
+		mcp.WithDescription("Show what the local mail cache holds: number of emails, index size, disk use and when it was last synced"),
+		readOnlyAnnotation,
+		outputFormatParam,
+	)
+	s.AddTool(statsTool, utils.ErrorGuard(gmailCacheStatsHandler))
+
+	// Cache purge tool
+	purgeTool := mcp.NewTool("gmail_cache_purge",
+		mcp.WithDescription("Delete the local mail cache of the current user. Gmail itself is not changed; "+
+			"the next search fills the cache again"),
+		outputFormatParam,
+	)
+	s.AddTool(purgeTool, utils.ErrorGuard(gmailCachePurgeHandler))
+}
+
+func gmailCacheStatsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+	c, err := mailCacheFor(ctx, srv)
+	if errors.Is(err, errCacheDisabled) {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if err != nil {
+		return googleErrorResult("open mail cache", err), nil
+	}
+
+	stats, err := c.store.Stats()
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	out := cacheStats{Messages: stats.Messages, Terms: stats.Terms, DiskBytes: stats.DiskBytes}
+	if stats.Messages > 0 {
+		out.Oldest = stats.Oldest.UTC().Format(time.RFC3339)
+		out.Newest = stats.Newest.UTC().Format(time.RFC3339)
+	}
+	if stats.State.Truncated {
+		out.Since = stats.State.Since.UTC().Format(time.RFC3339)
+	}
+	if stats.State.HistoryID != 0 {
+		out.HistoryID = strconv.FormatUint(stats.State.HistoryID, 10)
+		out.LastSync = stats.State.LastSync.UTC().Format(time.RFC3339)
+	}
+	return structuredResult("gmail_cache_stats", format, out), nil
+}
+
+func gmailCachePurgeHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+	c, err := mailCacheFor(ctx, srv)
+	if errors.Is(err, errCacheDisabled) {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if err != nil {
+		return googleErrorResult("open mail cache", err), nil
+	}
+
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	stats, err := c.store.Stats()
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if err := c.store.Purge(); err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	return structuredResult("gmail_cache_purge", format, cachePurgeResult{Messages: stats.Messages}), nil
+}
+
+func (s cacheStats) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Cached emails: %d\n", s.Messages))
+	result.WriteString(fmt.Sprintf("Indexed words: %d\n", s.Terms))
+	result.WriteString(fmt.Sprintf("Disk use: %d bytes\n", s.DiskBytes))
+	if s.Messages > 0 {
+		result.WriteString(fmt.Sprintf("Emails from %s to %s\n", s.Oldest, s.Newest))
+	}
+	if s.Since != "" {
+		result.WriteString(fmt.Sprintf("Older emails than %s are not cached; searches reaching back further go to Gmail.\n", s.Since))
+	}
+	if s.LastSync != "" {
+		result.WriteString(fmt.Sprintf("Last synced: %s (history ID %s)\n", s.LastSync, s.HistoryID))
+	} else {
+		result.WriteString("Not synced yet; the next search fills the cache.\n")
+	}
+	return result.String()
+}
+
+func (s cacheStats) Markdown() string {
+	var result strings.Builder
+	result.WriteString("| | |\n|---|---|\n")
+	result.WriteString(fmt.Sprintf("| Cached emails | %d |\n", s.Messages))
+	result.WriteString(fmt.Sprintf("| Indexed words | %d |\n", s.Terms))
+	result.WriteString(fmt.Sprintf("| Disk use | %d bytes |\n", s.DiskBytes))
+	if s.Messages > 0 {
+		result.WriteString(fmt.Sprintf("| Oldest email | %s |\n| Newest email | %s |\n", s.Oldest, s.Newest))
+	}
+	if s.Since != "" {
+		result.WriteString(fmt.Sprintf("| Complete since | %s |\n", s.Since))
+	}
+	lastSync := "not yet"
+	if s.LastSync != "" {
+		lastSync = fmt.Sprintf("%s (history ID `%s`)", s.LastSync, s.HistoryID)
+	}
+	result.WriteString(fmt.Sprintf("| Last synced | %s |\n", lastSync))
+	return result.String()
+}
+
+func (r cachePurgeResult) Text() string {
+	return fmt.Sprintf("Mail cache purged: %d emails deleted.", r.Messages)
+}
+
+func (r cachePurgeResult) Markdown() string {
+	return fmt.Sprintf("Mail cache purged: **%d** emails deleted.", r.Messages)
+}
@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxMessageBytes is Gmail's limit for a message including attachments.
+const maxMessageBytes = 25 << 20
+
+// composeParams are the arguments shared by every tool that writes a new
+// message.
+var composeParams = []mcp.ToolOption{
+	mcp.WithString("to", mcp.Required(), mcp.Description("Recipient email address(es), comma separated")),
+	mcp.WithString("cc", mcp.Description("Cc address(es), comma separated")),
+	mcp.WithString("bcc", mcp.Description("Bcc address(es), comma separated")),
+	mcp.WithString("from", mcp.Description("Send-as alias to send from, e.g. \"Support <support@example.com>\". It must be configured for the account")),
+	mcp.WithString("subject", mcp.Required(), mcp.Description("Email subject")),
+	mcp.WithString("body", mcp.Description("Plain text body")),
+	mcp.WithString("html_body", mcp.Description("HTML body; sent as an alternative to body when both are given")),
+	mcp.WithArray("attachments",
+		mcp.Description("Files to attach. Each needs a filename and either path (inside ATTACHMENT_DIR) or data_base64"),
+		mcp.Items(map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"filename":     map[string]interface{}{"type": "string"},
+				"content_type": map[string]interface{}{"type": "string", "description": "MIME type; guessed from the filename when omitted"},
+				"path":         map[string]interface{}{"type": "string", "description": "File path relative to ATTACHMENT_DIR"},
+				"data_base64":  map[string]interface{}{"type": "string", "description": "File content, standard base64"},
+				"inline":       map[string]interface{}{"type": "boolean", "description": "Embed as an inline image referenced from html_body as cid:<content_id>"},
+				"content_id":   map[string]interface{}{"type": "string"},
+			},
+			"required": []string{"filename"},
+		}),
+	),
+}
+
+// composeEmail builds the message described by the compose arguments.
+// Errors are meant to be shown to the caller.
+func composeEmail(request mcp.CallToolRequest) (*services.Email, error) {
+	args := request.Params.Arguments
+	to, ok := args["to"].(string)
+	if !ok || strings.TrimSpace(to) == "" {
+		return nil, errors.New("to must be a non-empty string")
+	}
+	subject, ok := args["subject"].(string)
+	if !ok {
+		return nil, errors.New("subject must be a string")
+	}
+	text, _ := args["body"].(string)
+	html, _ := args["html_body"].(string)
+	if text == "" && html == "" {
+		return nil, errors.New("body or html_body is required")
+	}
+	cc, _ := args["cc"].(string)
+	bcc, _ := args["bcc"].(string)
+	from, _ := args["from"].(string)
+
+	email := &services.Email{From: from, Subject: subject, Text: text, HTML: html}
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   *[]string
+	}{{"to", to, &email.To}, {"cc", cc, &email.Cc}, {"bcc", bcc, &email.Bcc}} {
+		addrs, err := services.ParseAddressList(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.dst = addressStrings(addrs)
+	}
+
+	attachments, err := parseAttachments(args["attachments"])
+	if err != nil {
+		return nil, err
+	}
+	email.Attachments = attachments
+	return email, nil
+}
+
+// parseAttachments reads the attachments argument.
+func parseAttachments(arg interface{}) ([]services.Attachment, error) {
+	if arg == nil {
+		return nil, nil
+	}
+	items, ok := arg.([]interface{})
+	if !ok {
+		return nil, errors.New("attachments must be an array")
+	}
+	var attachments []services.Attachment
+	total := 0
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("attachments[%d] must be an object", i)
+		}
+		filename, _ := obj["filename"].(string)
+		if filename == "" {
+			return nil, fmt.Errorf("attachments[%d]: filename is required", i)
+		}
+		a := services.Attachment{Filename: filepath.Base(filename)}
+		a.ContentType, _ = obj["content_type"].(string)
+		a.Inline, _ = obj["inline"].(bool)
+		a.ContentID, _ = obj["content_id"].(string)
+		if a.Inline && a.ContentID == "" {
+			return nil, fmt.Errorf("attachments[%d]: inline images need a content_id", i)
+		}
+
+		path, _ := obj["path"].(string)
+		data, _ := obj["data_base64"].(string)
+		var err error
+		switch {
+		case path != "" && data != "":
+			return nil, fmt.Errorf("attachments[%d]: give either path or data_base64, not both", i)
+		case path != "":
+			a.Data, err = readAttachmentFile(path)
+		case data != "":
+			a.Data, err = base64.StdEncoding.DecodeString(data)
+		default:
+			err = errors.New("path or data_base64 is required")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("attachments[%d] (%s): %w", i, filename, err)
+		}
+		if total += len(a.Data); total > maxMessageBytes {
+			return nil, fmt.Errorf("attachments exceed Gmail's %d MB message limit", maxMessageBytes>>20)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// readAttachmentFile reads path relative to ATTACHMENT_DIR. Attaching files
+// is disabled when ATTACHMENT_DIR is unset, and paths may not leave it.
+func readAttachmentFile(path string) ([]byte, error) {
+	dir := os.Getenv("ATTACHMENT_DIR")
+	if dir == "" {
+		return nil, errors.New("reading files by path is disabled; set ATTACHMENT_DIR or pass the content inline")
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ATTACHMENT_DIR: %w", err)
+	}
+	full, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path)))
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(root, full); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside ATTACHMENT_DIR", path)
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxMessageBytes {
+		return nil, fmt.Errorf("file exceeds Gmail's %d MB message limit", maxMessageBytes>>20)
+	}
+	return os.ReadFile(full)
+}
@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadAttachmentFile(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "attachments")
+	for path, content := range map[string]string{
+		filepath.Join(dir, "report.txt"):         "report",
+		filepath.Join(dir, "sub", "notes.txt"):   "notes",
+		filepath.Join(base, "secret.txt"):        "secret",
+		filepath.Join(base, "attachments2", "x"): "sibling",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"escape.txt": filepath.Join(base, "secret.txt"),
+		"outside":    base,
+		"inside.txt": filepath.Join(dir, "sub", "notes.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+	t.Setenv("ATTACHMENT_DIR", dir)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"report.txt", "report"},
+		{"sub/notes.txt", "notes"},
+		{"sub/../report.txt", "report"},
+		{"inside.txt", "notes"},
+		{"../secret.txt", ""},
+		{"sub/../../secret.txt", ""},
+		{"../attachments2/x", ""},
+		{"escape.txt", ""},
+		{"outside/secret.txt", ""},
+		{"/etc/passwd", ""},
+		{"missing.txt", ""},
+	}
+	for _, tt := range tests {
+		got, err := readAttachmentFile(tt.path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("readAttachmentFile(%q) = %q, want an error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("readAttachmentFile(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	t.Setenv("ATTACHMENT_DIR", "")
+	if _, err := readAttachmentFile("report.txt"); err == nil {
+		t.Error("readAttachmentFile works without ATTACHMENT_DIR")
+	}
+}
+
+func TestParseAttachments(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString([]byte("hello"))
+	tests := []struct {
+		name     string
+		arg      interface{}
+		wantName string
+		wantErr  bool
+	}{
+		{"none", nil, "", false},
+		{"inline data", []interface{}{map[string]interface{}{"filename": "a.txt", "data_base64": data}}, "a.txt", false},
+		{"directories dropped from the name", []interface{}{map[string]interface{}{"filename": "../../etc/a.txt", "data_base64": data}}, "a.txt", false},
+		{"not an array", "a.txt", "", true},
+		{"no filename", []interface{}{map[string]interface{}{"data_base64": data}}, "", true},
+		{"no content", []interface{}{map[string]interface{}{"filename": "a.txt"}}, "", true},
+		{"path and data", []interface{}{map[string]interface{}{"filename": "a.txt", "path": "a.txt", "data_base64": data}}, "", true},
+		{"bad base64", []interface{}{map[string]interface{}{"filename": "a.txt", "data_base64": "%%%"}}, "", true},
+		{"inline without content_id", []interface{}{map[string]interface{}{"filename": "a.png", "data_base64": data, "inline": true}}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseAttachments(tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantName != "" && (len(got) != 1 || got[0].Filename != tt.wantName || string(got[0].Data) != "hello") {
+			t.Errorf("%s: attachments = %+v, want %s", tt.name, got, tt.wantName)
+		}
+	}
+}
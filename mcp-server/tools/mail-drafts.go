@@ -0,0 +1,341 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+type draftResult struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+	ThreadID  string `json:"thread_id"`
+}
+
+type draftSummary struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+	Snippet   string `json:"snippet"`
+}
+
+type draftListResult struct {
+	Drafts        []draftSummary `json:"drafts"`
+	NextPageToken string         `json:"next_page_token,omitempty" desc:"Pass as page_token to fetch the next page"`
+}
+
+type deleteDraftResult struct {
+	ID string `json:"id"`
+}
+
+func registerDraftTools(s ToolAdder) {
+	// Create draft tool
+	createDraftTool := mcp.NewTool("gmail_create_draft", append([]mcp.ToolOption{
+		mcp.WithDescription("Save an email as a Gmail draft for a person to review and send, instead of sending it"),
+		outputFormatParam,
+	}, composeParams...)...)
+	s.AddTool(createDraftTool, utils.ErrorGuard(gmailCreateDraftHandler))
+
+	// Update draft tool
+	updateDraftTool := mcp.NewTool("gmail_update_draft", append([]mcp.ToolOption{
+		mcp.WithDescription("Replace the content of an existing Gmail draft"),
+		mcp.WithString("draft_id", mcp.Required(), mcp.Description("ID of the draft to replace")),
+		outputFormatParam,
+	}, composeParams...)...)
+	s.AddTool(updateDraftTool, utils.ErrorGuard(gmailUpdateDraftHandler))
+
+	// List drafts tool
+	listDraftsTool := mcp.NewTool("gmail_list_drafts",
+		mcp.WithDescription("List Gmail drafts with their recipients and subject"),
+		readOnlyAnnotation,
+		mcp.WithString("query", mcp.Description("Gmail search query to filter drafts")),
+		mcp.WithNumber("max_results", mcp.Description(fmt.Sprintf("Maximum number of drafts to return (default %d, at most %d)", defaultSearchResults, maxSearchResults))),
+		mcp.WithString("page_token", mcp.Description("next_page_token from a previous listing, to fetch the following page")),
+		outputFormatParam,
+	)
+	s.AddTool(listDraftsTool, utils.ErrorGuard(gmailListDraftsHandler))
+
+	// Send draft tool
+	sendDraftTool := mcp.NewTool("gmail_send_draft",
+		mcp.WithDescription("Send an existing Gmail draft as it is"),
+		mcp.WithString("draft_id", mcp.Required(), mcp.Description("ID of the draft to send")),
+		outputFormatParam,
+	)
+	s.AddTool(sendDraftTool, utils.ErrorGuard(gmailSendDraftHandler))
+
+	// Delete draft tool
+	deleteDraftTool := mcp.NewTool("gmail_delete_draft",
+		mcp.WithDescription("Permanently delete a Gmail draft"),
+		mcp.WithString("draft_id", mcp.Required(), mcp.Description("ID of the draft to delete")),
+		outputFormatParam,
+	)
+	s.AddTool(deleteDraftTool, utils.ErrorGuard(gmailDeleteDraftHandler))
+}
+
+func gmailCreateDraftHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	email, err := composeEmail(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	msg, err := rawMessage(email, "")
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	draft, err := srv.Users.Drafts.Create("me", &gmail.Draft{Message: msg}).Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("create draft", err), nil
+	}
+	return structuredResult("gmail_create_draft", format, newDraftResult(draft)), nil
+}
+
+func gmailUpdateDraftHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	draftID, ok := request.Params.Arguments["draft_id"].(string)
+	if !ok || draftID == "" {
+		return mcp.NewToolResultError("draft_id must be a non-empty string"), nil
+	}
+	email, err := composeEmail(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	// Keep the draft in its thread, e.g. a drafted reply.
+	existing, err := srv.Users.Drafts.Get("me", draftID).Format("minimal").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get draft", err), nil
+	}
+	msg, err := rawMessage(email, existing.Message.ThreadId)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	draft, err := srv.Users.Drafts.Update("me", draftID, &gmail.Draft{Id: draftID, Message: msg}).Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("update draft", err), nil
+	}
+	return structuredResult("gmail_update_draft", format, newDraftResult(draft)), nil
+}
+
+func gmailListDraftsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	query, _ := request.Params.Arguments["query"].(string)
+	maxResults := mcp.ParseInt64(request, "max_results", defaultSearchResults)
+	if maxResults < 1 || maxResults > maxSearchResults {
+		return mcp.NewToolResultError(fmt.Sprintf("max_results must be between 1 and %d", maxSearchResults)), nil
+	}
+	pageToken, _ := request.Params.Arguments["page_token"].(string)
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	listCall := srv.Users.Drafts.List("me").Q(query).MaxResults(maxResults).Context(ctx)
+	if pageToken != "" {
+		listCall.PageToken(pageToken)
+	}
+	resp, err := listCall.Do()
+	if err != nil {
+		return googleErrorResult("list drafts", err), nil
+	}
+
+	ids := make([]string, len(resp.Drafts))
+	for i, draft := range resp.Drafts {
+		ids[i] = draft.Id
+	}
+	drafts, err := fetchConcurrently(ctx, ids, func(ctx context.Context, id string) (*gmail.Draft, error) {
+		return srv.Users.Drafts.Get("me", id).Format("metadata").Context(ctx).Do()
+	})
+	if err != nil {
+		return googleErrorResult("list drafts", err), nil
+	}
+
+	out := draftListResult{Drafts: []draftSummary{}, NextPageToken: resp.NextPageToken}
+	for _, draft := range drafts {
+		summary := draftSummary{ID: draft.Id}
+		if draft.Message != nil {
+			summary.MessageID = draft.Message.Id
+			summary.To = headerValue(draft.Message.Payload, "To")
+			summary.Subject = headerValue(draft.Message.Payload, "Subject")
+			summary.Snippet = draft.Message.Snippet
+		}
+		out.Drafts = append(out.Drafts, summary)
+	}
+	return structuredResult("gmail_list_drafts", format, out), nil
+}
+
+func gmailSendDraftHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	draftID, ok := request.Params.Arguments["draft_id"].(string)
+	if !ok || draftID == "" {
+		return mcp.NewToolResultError("draft_id must be a non-empty string"), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	// The send policy applies to drafts too, so check what is in the draft
+	// now rather than what was there when it was written.
+	draft, err := srv.Users.Drafts.Get("me", draftID).Format("raw").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get draft", err), nil
+	}
+	raw, err := decodeBodyData(draft.Message.Raw)
+	if err != nil {
+		return googleErrorResult("read draft", err), nil
+	}
+	headers, err := draftHeaders(raw)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("draft has invalid recipients: %v", err)), nil
+	}
+	recipients, err := headers.Recipients()
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("draft has invalid recipients: %v", err)), nil
+	}
+
+	sent, err := sendUnderPolicy(ctx, recipients, raw, func() (*gmail.Message, error) {
+		return srv.Users.Drafts.Send("me", &gmail.Draft{Id: draftID}).Context(ctx).Do()
+	})
+	if err != nil {
+		return googleErrorResult("send draft", err), nil
+	}
+	return structuredResult("gmail_send_draft", format, sent), nil
+}
+
+func gmailDeleteDraftHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	draftID, ok := request.Params.Arguments["draft_id"].(string)
+	if !ok || draftID == "" {
+		return mcp.NewToolResultError("draft_id must be a non-empty string"), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	if err := srv.Users.Drafts.Delete("me", draftID).Context(ctx).Do(); err != nil {
+		return googleErrorResult("delete draft", err), nil
+	}
+	return structuredResult("gmail_delete_draft", format, deleteDraftResult{ID: draftID}), nil
+}
+
+// draftHeaders returns the To, Cc, Bcc and decoded Subject of a raw
+// message, without its content.
+func draftHeaders(raw []byte) (*services.Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	email := &services.Email{}
+	for _, field := range []struct {
+		name string
+		dst  *[]string
+	}{{"To", &email.To}, {"Cc", &email.Cc}, {"Bcc", &email.Bcc}} {
+		addrs, err := msg.Header.AddressList(field.name)
+		if err != nil && !errors.Is(err, mail.ErrHeaderNotPresent) {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.dst = addressStrings(addrs)
+	}
+	email.Subject = msg.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(email.Subject); err == nil {
+		email.Subject = decoded
+	}
+	return email, nil
+}
+
+func newDraftResult(draft *gmail.Draft) draftResult {
+	out := draftResult{ID: draft.Id}
+	if draft.Message != nil {
+		out.MessageID = draft.Message.Id
+		out.ThreadID = draft.Message.ThreadId
+	}
+	return out
+}
+
+func (r draftResult) Text() string {
+	return fmt.Sprintf("Draft saved (Draft ID: %s). Review it in Gmail or send it with gmail_send_draft.", r.ID)
+}
+
+func (r draftResult) Markdown() string {
+	return fmt.Sprintf("Draft saved: `%s`. Review it in Gmail or send it with `gmail_send_draft`.", r.ID)
+}
+
+func (r draftListResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Found %d drafts:\n\n", len(r.Drafts)))
+	for _, draft := range r.Drafts {
+		result.WriteString(fmt.Sprintf("Draft ID: %s\n", draft.ID))
+		result.WriteString(fmt.Sprintf("To: %s\n", draft.To))
+		result.WriteString(fmt.Sprintf("Subject: %s\n", draft.Subject))
+		result.WriteString(fmt.Sprintf("Snippet: %s\n", draft.Snippet))
+		result.WriteString("-------------------\n")
+	}
+	if r.NextPageToken != "" {
+		result.WriteString(fmt.Sprintf("Next page token: %s\n", r.NextPageToken))
+	}
+	return result.String()
+}
+
+func (r draftListResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**Found %d drafts**\n\n", len(r.Drafts)))
+	if len(r.Drafts) > 0 {
+		result.WriteString("| To | Subject | Draft ID |\n|---|---|---|\n")
+		for _, draft := range r.Drafts {
+			result.WriteString(fmt.Sprintf("| %s | %s | `%s` |\n", markdownCell(draft.To), markdownCell(draft.Subject), draft.ID))
+		}
+	}
+	if r.NextPageToken != "" {
+		result.WriteString(fmt.Sprintf("\nNext page token: `%s`\n", r.NextPageToken))
+	}
+	return result.String()
+}
+
+func (r deleteDraftResult) Text() string {
+	return "Draft deleted."
+}
+
+func (r deleteDraftResult) Markdown() string {
+	return fmt.Sprintf("Draft `%s` deleted.", r.ID)
+}
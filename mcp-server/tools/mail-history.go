@@ -0,0 +1,423 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// defaultHistoryChanges is how many history records one call reads by
+	// default, and the size of the full sync after the history expired.
+	defaultHistoryChanges = 100
+	maxHistoryChanges     = 500
+)
+
+// errHistoryExpired means Gmail no longer has the history since the given
+// ID, which happens after about a week, and a full sync is needed.
+var errHistoryExpired = errors.New("mailbox history expired")
+
+// History cursors remembered per MCP client session and label, in memory.
+// The label is "" for calls without one.
+var (
+	historyMu      sync.Mutex
+	historyCursors = make(map[string]map[string]uint64)
+)
+
+type labelChange struct {
+	MessageID string   `json:"message_id"`
+	Added     []string `json:"added_labels,omitempty" desc:"Label IDs added"`
+	Removed   []string `json:"removed_labels,omitempty" desc:"Label IDs removed"`
+}
+
+type changesResult struct {
+	HistoryID    string         `json:"history_id" desc:"Pass as history_id to get the changes after this call; the session also remembers it"`
+	Since        string         `json:"since,omitempty" desc:"History ID the changes are relative to"`
+	Baseline     bool           `json:"baseline,omitempty" desc:"Set on the first call of a session, which only records where the mailbox is"`
+	FullSync     bool           `json:"full_sync,omitempty" desc:"Set when the history had expired; added then lists the most recent emails"`
+	Added        []emailSummary `json:"added"`
+	Deleted      []string       `json:"deleted" desc:"IDs of deleted emails"`
+	LabelChanges []labelChange  `json:"label_changes"`
+	More         bool           `json:"more,omitempty" desc:"Set when there are more changes; call again to get them. After a full sync it means older emails were left out, and calling again does not return them"`
+}
+
+// mailboxChanges are the net changes of a stretch of mailbox history.
+// Messages added in it show up in Added only, and messages added and
+// deleted in it not at all.
+type mailboxChanges struct {
+	HistoryID    uint64
+	Added        []string
+	Deleted      []string
+	LabelChanges []labelChange
+	More         bool
+}
+
+func registerHistoryTools(s ToolAdder) {
+	// Changes since tool
+	changesTool := mcp.NewTool("gmail_changes_since",
+		mcp.WithDescription("List the emails added and deleted and the label changes since a history ID. "+
+			"Without history_id it continues from the previous call in this session; the first call only returns "+
+			"the current history ID. Use it instead of repeating searches to check for new mail"),
+		readOnlyAnnotation,
+		mcp.WithString("history_id", mcp.Description("History ID from an earlier call, or from an email")),
+		mcp.WithString("label", mcp.Description("Only report changes to emails with this label, by name or ID, e.g. INBOX")),
+		mcp.WithNumber("max_changes", mcp.Description(fmt.Sprintf("Most history records to read (default %d, at most %d)", defaultHistoryChanges, maxHistoryChanges))),
+		outputFormatParam,
+	)
+	s.AddTool(changesTool, utils.ErrorGuard(gmailChangesSinceHandler))
+}
+
+func gmailChangesSinceHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	key := clientSessionKey(ctx)
+	historyID, _ := request.Params.Arguments["history_id"].(string)
+	maxChanges := mcp.ParseInt64(request, "max_changes", defaultHistoryChanges)
+	if maxChanges < 1 || maxChanges > maxHistoryChanges {
+		return mcp.NewToolResultError(fmt.Sprintf("max_changes must be between 1 and %d", maxHistoryChanges)), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	labelID := ""
+	if label, _ := request.Params.Arguments["label"].(string); label != "" {
+		labels, err := srv.Users.Labels.List("me").Context(ctx).Do()
+		if err != nil {
+			return googleErrorResult("list labels", err), nil
+		}
+		ids, err := resolveLabelIDs(labels.Labels, []string{label})
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		labelID = ids[0]
+	}
+
+	// A cursor only continues calls for the same label, since changes to
+	// other labels were never reported under it.
+	var since uint64
+	if historyID != "" {
+		since, err = strconv.ParseUint(historyID, 10, 64)
+		if err != nil {
+			return mcp.NewToolResultError("history_id must be a number"), nil
+		}
+	} else {
+		historyMu.Lock()
+		since = historyCursors[key][labelID]
+		historyMu.Unlock()
+	}
+
+	out := changesResult{Added: []emailSummary{}, Deleted: []string{}, LabelChanges: []labelChange{}}
+	var addedIDs []string
+	if since == 0 {
+		profile, err := srv.Users.GetProfile("me").Context(ctx).Do()
+		if err != nil {
+			return googleErrorResult("get mailbox history ID", err), nil
+		}
+		out.Baseline = true
+		out.HistoryID = strconv.FormatUint(profile.HistoryId, 10)
+	} else {
+		out.Since = strconv.FormatUint(since, 10)
+		changes, err := historyChanges(ctx, srv, since, labelID, maxChanges)
+		if errors.Is(err, errHistoryExpired) {
+			changes, err = fullSync(ctx, srv, labelID, maxChanges)
+			out.FullSync = true
+		}
+		if err != nil {
+			return googleErrorResult("list mailbox changes", err), nil
+		}
+		out.HistoryID = strconv.FormatUint(changes.HistoryID, 10)
+		out.Deleted = nonNil(changes.Deleted)
+		if changes.LabelChanges != nil {
+			out.LabelChanges = changes.LabelChanges
+		}
+		out.More = changes.More
+		addedIDs = changes.Added
+	}
+
+	messages, err := fetchMessageMetadata(ctx, srv, addedIDs, searchHeaders)
+	if err != nil {
+		return googleErrorResult("list mailbox changes", err), nil
+	}
+	for _, message := range messages {
+		out.Added = append(out.Added, newEmailSummary(message))
+	}
+
+	cursor, _ := strconv.ParseUint(out.HistoryID, 10, 64)
+	historyMu.Lock()
+	if historyCursors[key] == nil {
+		historyCursors[key] = make(map[string]uint64)
+	}
+	historyCursors[key][labelID] = cursor
+	historyMu.Unlock()
+	return structuredResult("gmail_changes_since", format, out), nil
+}
+
+// historyChanges reads up to maxRecords history records after since,
+// limited to labelID when it is set. It fails with errHistoryExpired when
+// since is too old.
+func historyChanges(ctx context.Context, srv *gmail.Service, since uint64, labelID string, maxRecords int64) (mailboxChanges, error) {
+	var (
+		out     mailboxChanges
+		order   []string
+		seen    = make(map[string]bool)
+		added   = make(map[string]bool)
+		deleted = make(map[string]bool)
+		// labelDelta holds per message +1 for added and -1 for removed labels.
+		labelDelta = make(map[string]map[string]int)
+		read       int64
+		pageToken  string
+	)
+	note := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			order = append(order, id)
+		}
+	}
+	relabel := func(id string, labelIDs []string, delta int) {
+		note(id)
+		if added[id] || deleted[id] {
+			return
+		}
+		if labelDelta[id] == nil {
+			labelDelta[id] = make(map[string]int)
+		}
+		for _, label := range labelIDs {
+			labelDelta[id][label] = max(-1, min(1, labelDelta[id][label]+delta))
+		}
+	}
+
+	for {
+		call := srv.Users.History.List("me").StartHistoryId(since).MaxResults(min(maxRecords-read, 500)).Context(ctx)
+		if labelID != "" {
+			call.LabelId(labelID)
+		}
+		if pageToken != "" {
+			call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+				return mailboxChanges{}, fmt.Errorf("%w: %v", errHistoryExpired, err)
+			}
+			return mailboxChanges{}, err
+		}
+
+		for _, record := range resp.History {
+			for _, m := range record.MessagesAdded {
+				note(m.Message.Id)
+				added[m.Message.Id] = true
+				delete(labelDelta, m.Message.Id)
+			}
+			for _, m := range record.MessagesDeleted {
+				note(m.Message.Id)
+				// A message both added and deleted here was never seen.
+				deleted[m.Message.Id] = !added[m.Message.Id]
+				added[m.Message.Id] = false
+				delete(labelDelta, m.Message.Id)
+			}
+			for _, m := range record.LabelsAdded {
+				relabel(m.Message.Id, m.LabelIds, 1)
+			}
+			for _, m := range record.LabelsRemoved {
+				relabel(m.Message.Id, m.LabelIds, -1)
+			}
+			out.HistoryID = record.Id
+		}
+		read += int64(len(resp.History))
+
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			// Without more records the mailbox is current up to its
+			// latest history ID.
+			out.HistoryID = max(out.HistoryID, resp.HistoryId)
+			break
+		}
+		if read >= maxRecords {
+			out.More = true
+			break
+		}
+	}
+	if out.HistoryID == 0 {
+		out.HistoryID = since
+	}
+
+	for _, id := range order {
+		switch {
+		case added[id]:
+			out.Added = append(out.Added, id)
+		case deleted[id]:
+			out.Deleted = append(out.Deleted, id)
+		case labelDelta[id] != nil:
+			change := labelChange{MessageID: id}
+			for label, delta := range labelDelta[id] {
+				switch delta {
+				case 1:
+					change.Added = append(change.Added, label)
+				case -1:
+					change.Removed = append(change.Removed, label)
+				}
+			}
+			sort.Strings(change.Added)
+			sort.Strings(change.Removed)
+			if change.Added != nil || change.Removed != nil {
+				out.LabelChanges = append(out.LabelChanges, change)
+			}
+		}
+	}
+	return out, nil
+}
+
+// fullSync stands in for history that expired: it returns the newest
+// maxMessages messages, limited to labelID when it is set, as added. The
+// history ID is read first so that no later change is missed.
+func fullSync(ctx context.Context, srv *gmail.Service, labelID string, maxMessages int64) (mailboxChanges, error) {
+	profile, err := srv.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return mailboxChanges{}, err
+	}
+	out := mailboxChanges{HistoryID: profile.HistoryId}
+	pageToken := ""
+	for {
+		call := srv.Users.Messages.List("me").MaxResults(min(maxMessages-int64(len(out.Added)), 500)).Context(ctx)
+		if labelID != "" {
+			call.LabelIds(labelID)
+		}
+		if pageToken != "" {
+			call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return mailboxChanges{}, err
+		}
+		for _, message := range resp.Messages {
+			out.Added = append(out.Added, message.Id)
+		}
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			return out, nil
+		}
+		if int64(len(out.Added)) >= maxMessages {
+			out.More = true
+			return out, nil
+		}
+	}
+}
+
+// ForgetHistoryCursor drops the remembered history IDs of a client session
+// once it ends.
+func ForgetHistoryCursor(ctx context.Context, cs server.ClientSession) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	delete(historyCursors, cs.SessionID())
+}
+
+func (c labelChange) describe() string {
+	var parts []string
+	if len(c.Added) > 0 {
+		parts = append(parts, "+"+strings.Join(c.Added, " +"))
+	}
+	if len(c.Removed) > 0 {
+		parts = append(parts, "-"+strings.Join(c.Removed, " -"))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r changesResult) summary() string {
+	switch {
+	case r.Baseline:
+		return fmt.Sprintf("Now at history ID %s; call again to get the changes after it.", r.HistoryID)
+	case r.FullSync && r.More:
+		return fmt.Sprintf("The history since %s has expired; these are the %d most recent emails, and older ones are left out.", r.Since, len(r.Added))
+	case r.FullSync:
+		return fmt.Sprintf("The history since %s has expired; these are all %d emails.", r.Since, len(r.Added))
+	}
+	return fmt.Sprintf("Since history ID %s: %d new, %d deleted, %d relabelled.",
+		r.Since, len(r.Added), len(r.Deleted), len(r.LabelChanges))
+}
+
+// moreNote tells how to get what the result left out.
+func (r changesResult) moreNote() string {
+	if r.FullSync {
+		return "Calling again only returns changes from now on; search with a date range to list older emails."
+	}
+	return "There are more changes; call again to get them."
+}
+
+func (r changesResult) Text() string {
+	var result strings.Builder
+	result.WriteString(r.summary() + "\n")
+	if !r.Baseline {
+		result.WriteString(fmt.Sprintf("History ID: %s\n", r.HistoryID))
+	}
+	if len(r.Added) > 0 {
+		result.WriteString("\nNew:\n")
+		for _, message := range r.Added {
+			result.WriteString(fmt.Sprintf("Message ID: %s\n", message.ID))
+			result.WriteString(fmt.Sprintf("From: %s\n", message.From))
+			result.WriteString(fmt.Sprintf("Subject: %s\n", message.Subject))
+			result.WriteString(fmt.Sprintf("Date: %s\n", message.Date))
+			result.WriteString("-------------------\n")
+		}
+	}
+	if len(r.Deleted) > 0 {
+		result.WriteString("\nDeleted: " + strings.Join(r.Deleted, ", ") + "\n")
+	}
+	if len(r.LabelChanges) > 0 {
+		result.WriteString("\nLabel changes:\n")
+		for _, c := range r.LabelChanges {
+			result.WriteString(fmt.Sprintf("%s: %s\n", c.MessageID, c.describe()))
+		}
+	}
+	if r.More {
+		result.WriteString("\n" + r.moreNote() + "\n")
+	}
+	return result.String()
+}
+
+func (r changesResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString("**" + r.summary() + "**\n")
+	if !r.Baseline {
+		result.WriteString(fmt.Sprintf("\nHistory ID: `%s`\n", r.HistoryID))
+	}
+	if len(r.Added) > 0 {
+		result.WriteString("\n### New\n\n| From | Subject | Date | Message ID |\n|---|---|---|---|\n")
+		for _, message := range r.Added {
+			result.WriteString(fmt.Sprintf("| %s | %s | %s | `%s` |\n",
+				markdownCell(message.From), markdownCell(message.Subject), markdownCell(message.Date), message.ID))
+		}
+	}
+	if len(r.Deleted) > 0 {
+		result.WriteString("\n### Deleted\n\n")
+		for _, id := range r.Deleted {
+			result.WriteString(fmt.Sprintf("- `%s`\n", id))
+		}
+	}
+	if len(r.LabelChanges) > 0 {
+		result.WriteString("\n### Label changes\n\n")
+		for _, c := range r.LabelChanges {
+			result.WriteString(fmt.Sprintf("- `%s`: %s\n", c.MessageID, c.describe()))
+		}
+	}
+	if r.More {
+		result.WriteString("\n" + r.moreNote() + "\n")
+	}
+	return result.String()
+}
@@ -0,0 +1,407 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/api/gmail/v1"
+)
+
+const (
+	// defaultBulkMessages is how many messages a query selects by default.
+	defaultBulkMessages = 100
+	// maxBulkMessages is the most BatchModify accepts in one call.
+	maxBulkMessages = 1000
+)
+
+type labelInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type" desc:"system or user"`
+}
+
+type labelListResult struct {
+	Labels []labelInfo `json:"labels"`
+}
+
+type modifyLabelsResult struct {
+	Action     string   `json:"action" desc:"What was done, e.g. archived"`
+	MessageIDs []string `json:"message_ids" desc:"Messages that were changed"`
+	Added      []string `json:"added_labels" desc:"Label IDs added"`
+	Removed    []string `json:"removed_labels" desc:"Label IDs removed"`
+	More       bool     `json:"more,omitempty" desc:"Set when the query matched more messages than max_messages"`
+	NextQuery  string   `json:"next_query,omitempty" desc:"When more is set, a query for the matching messages still left unchanged"`
+}
+
+// triageTools are one-step label changes. Like gmail_modify_labels they
+// apply to message_ids or to the messages matching query.
+var triageTools = []struct {
+	name        string
+	description string
+	action      string
+	add, remove []string
+}{
+	{"gmail_archive", "Archive emails: remove them from the inbox, keeping them in All Mail", "archived", nil, []string{"INBOX"}},
+	{"gmail_trash", "Move emails to the trash, where Gmail deletes them for good after 30 days", "moved to the trash", []string{"TRASH"}, nil},
+	{"gmail_untrash", "Restore emails from the trash", "restored from the trash", nil, []string{"TRASH"}},
+	{"gmail_star", "Star emails", "starred", []string{"STARRED"}, nil},
+	{"gmail_mark_unread", "Mark emails as unread", "marked as unread", []string{"UNREAD"}, nil},
+	{"gmail_mark_spam", "Report emails as spam, moving them out of the inbox", "marked as spam", []string{"SPAM"}, []string{"INBOX"}},
+}
+
+// targetParams select the messages a bulk tool changes.
+var targetParams = []mcp.ToolOption{
+	mcp.WithArray("message_ids", mcp.Description("IDs of the emails to change"), mcp.Items(map[string]interface{}{"type": "string"})),
+	mcp.WithString("query", mcp.Description("Gmail search query selecting the emails to change, instead of message_ids")),
+	mcp.WithNumber("max_messages", mcp.Description(fmt.Sprintf("Most emails a query may select (default %d, at most %d)", defaultBulkMessages, maxBulkMessages))),
+	outputFormatParam,
+}
+
+func registerLabelTools(s ToolAdder) {
+	// List labels tool
+	listLabelsTool := mcp.NewTool("gmail_list_labels",
+		mcp.WithDescription("List the Gmail labels of the account, system and user defined, with their IDs"),
+		readOnlyAnnotation,
+		outputFormatParam,
+	)
+	s.AddTool(listLabelsTool, utils.ErrorGuard(gmailListLabelsHandler))
+
+	// Create label tool
+	createLabelTool := mcp.NewTool("gmail_create_label",
+		mcp.WithDescription("Create a Gmail label. Use \"/\" in the name to nest it under another label"),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Label name, e.g. \"Clients/Acme\"")),
+		outputFormatParam,
+	)
+	s.AddTool(createLabelTool, utils.ErrorGuard(gmailCreateLabelHandler))
+
+	// Modify labels tool
+	modifyLabelsTool := mcp.NewTool("gmail_modify_labels", append([]mcp.ToolOption{
+		mcp.WithDescription("Add and remove labels on emails, given by ID or selected with a search query. " +
+			"Labels are given by name or ID; system labels include INBOX, UNREAD, STARRED, IMPORTANT, SPAM and TRASH"),
+		mcp.WithArray("add_labels", mcp.Description("Labels to add"), mcp.Items(map[string]interface{}{"type": "string"})),
+		mcp.WithArray("remove_labels", mcp.Description("Labels to remove"), mcp.Items(map[string]interface{}{"type": "string"})),
+	}, targetParams...)...)
+	s.AddTool(modifyLabelsTool, utils.ErrorGuard(gmailModifyLabelsHandler))
+
+	for _, t := range triageTools {
+		opts := []mcp.ToolOption{mcp.WithDescription(t.description + ". Give message_ids or a search query")}
+		tool := mcp.NewTool(t.name, append(opts, targetParams...)...)
+		s.AddTool(tool, utils.ErrorGuard(triageHandler(t.name, t.action, t.add, t.remove)))
+	}
+}
+
+func gmailListLabelsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	resp, err := srv.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("list labels", err), nil
+	}
+	out := labelListResult{Labels: []labelInfo{}}
+	for _, label := range resp.Labels {
+		out.Labels = append(out.Labels, labelInfo{ID: label.Id, Name: label.Name, Type: label.Type})
+	}
+	// System labels first, then by name.
+	sort.Slice(out.Labels, func(i, j int) bool {
+		if out.Labels[i].Type != out.Labels[j].Type {
+			return out.Labels[i].Type == "system"
+		}
+		return out.Labels[i].Name < out.Labels[j].Name
+	})
+	return structuredResult("gmail_list_labels", format, out), nil
+}
+
+func gmailCreateLabelHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	name, ok := request.Params.Arguments["name"].(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		return mcp.NewToolResultError("name must be a non-empty string"), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	label, err := srv.Users.Labels.Create("me", &gmail.Label{
+		Name:                  strings.TrimSpace(name),
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}).Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("create label", err), nil
+	}
+	return structuredResult("gmail_create_label", format, labelInfo{ID: label.Id, Name: label.Name, Type: label.Type}), nil
+}
+
+func gmailModifyLabelsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	add, err := stringList(request.Params.Arguments["add_labels"], "add_labels")
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	remove, err := stringList(request.Params.Arguments["remove_labels"], "remove_labels")
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return mcp.NewToolResultError("add_labels or remove_labels is required"), nil
+	}
+
+	labels, err := srv.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("list labels", err), nil
+	}
+	addIDs, err := resolveLabelIDs(labels.Labels, add)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	removeIDs, err := resolveLabelIDs(labels.Labels, remove)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	names := make(map[string]string, len(labels.Labels))
+	for _, label := range labels.Labels {
+		if label.Type == "user" {
+			names[label.Id] = label.Name
+		}
+	}
+	return modifyMessageLabels(ctx, srv, request, "gmail_modify_labels", "relabelled", addIDs, removeIDs, names)
+}
+
+// triageHandler returns the handler of a triageTools entry.
+func triageHandler(toolName, action string, add, remove []string) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		srv, err := gmailServiceFor(ctx)
+		if err != nil {
+			return googleErrorResult("create Gmail client", err), nil
+		}
+		return modifyMessageLabels(ctx, srv, request, toolName, action, add, remove, nil)
+	}
+}
+
+// modifyMessageLabels applies a label change to the messages selected by
+// targetParams with a single BatchModify call. names maps user label IDs
+// to their names, for searching.
+func modifyMessageLabels(ctx context.Context, srv *gmail.Service, request mcp.CallToolRequest, toolName, action string, add, remove []string, names map[string]string) (*mcp.CallToolResult, error) {
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	ids, err := stringList(request.Params.Arguments["message_ids"], "message_ids")
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	query, _ := request.Params.Arguments["query"].(string)
+	maxMessages := mcp.ParseInt64(request, "max_messages", defaultBulkMessages)
+
+	out := modifyLabelsResult{Action: action, Added: nonNil(add), Removed: nonNil(remove)}
+	switch {
+	case len(ids) > 0 && query != "":
+		return mcp.NewToolResultError("give either message_ids or query, not both"), nil
+	case len(ids) > maxBulkMessages:
+		return mcp.NewToolResultError(fmt.Sprintf("at most %d message_ids can be changed at once", maxBulkMessages)), nil
+	case query != "":
+		if maxMessages < 1 || maxMessages > maxBulkMessages {
+			return mcp.NewToolResultError(fmt.Sprintf("max_messages must be between 1 and %d", maxBulkMessages)), nil
+		}
+		// Restoring from the trash or spam has to look there.
+		includeSpamTrash := slices.Contains(remove, "TRASH") || slices.Contains(remove, "SPAM")
+		ids, out.More, err = queryMessageIDs(ctx, srv, query, maxMessages, includeSpamTrash)
+		if err != nil {
+			return googleErrorResult("search emails", err), nil
+		}
+		if out.More {
+			out.NextQuery = pendingQuery(query, add, remove, names)
+		}
+	case len(ids) == 0:
+		return mcp.NewToolResultError("message_ids or query is required"), nil
+	}
+	out.MessageIDs = nonNil(ids)
+
+	if len(ids) > 0 {
+		err = srv.Users.Messages.BatchModify("me", &gmail.BatchModifyMessagesRequest{
+			Ids:            ids,
+			AddLabelIds:    add,
+			RemoveLabelIds: remove,
+		}).Context(ctx).Do()
+		if err != nil {
+			return googleErrorResult("change labels", err), nil
+		}
+	}
+	return structuredResult(toolName, format, out), nil
+}
+
+// queryMessageIDs returns up to max IDs of messages matching query, and
+// whether more matched.
+func queryMessageIDs(ctx context.Context, srv *gmail.Service, query string, max int64, includeSpamTrash bool) ([]string, bool, error) {
+	var ids []string
+	pageToken := ""
+	for {
+		call := srv.Users.Messages.List("me").Q(query).MaxResults(min(max-int64(len(ids)), 500)).
+			IncludeSpamTrash(includeSpamTrash).Context(ctx)
+		if pageToken != "" {
+			call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, message := range resp.Messages {
+			ids = append(ids, message.Id)
+		}
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			return ids, false, nil
+		}
+		if int64(len(ids)) >= max {
+			return ids, true, nil
+		}
+	}
+}
+
+// systemLabelTerms are the search operators for system labels.
+var systemLabelTerms = map[string]string{
+	"INBOX":     "in:inbox",
+	"TRASH":     "in:trash",
+	"SPAM":      "in:spam",
+	"SENT":      "in:sent",
+	"DRAFT":     "in:drafts",
+	"STARRED":   "is:starred",
+	"UNREAD":    "is:unread",
+	"IMPORTANT": "is:important",
+}
+
+// labelSearchTerm returns the search operator matching label id.
+func labelSearchTerm(id string, names map[string]string) string {
+	if term, ok := systemLabelTerms[id]; ok {
+		return term
+	}
+	if category, ok := strings.CutPrefix(id, "CATEGORY_"); ok {
+		return "category:" + strings.ToLower(category)
+	}
+	name := id
+	if n, ok := names[id]; ok {
+		name = n
+	}
+	// Gmail searches label names lower case, with spaces and nesting
+	// slashes written as hyphens.
+	return "label:" + strings.NewReplacer(" ", "-", "/", "-").Replace(strings.ToLower(name))
+}
+
+// pendingQuery narrows query to the messages the label change has not yet
+// reached: those lacking a label it adds or keeping one it removes. Run
+// again, it picks up where the last batch stopped instead of matching the
+// changed messages forever.
+func pendingQuery(query string, add, remove []string, names map[string]string) string {
+	var terms []string
+	for _, id := range add {
+		terms = append(terms, "-"+labelSearchTerm(id, names))
+	}
+	for _, id := range remove {
+		terms = append(terms, labelSearchTerm(id, names))
+	}
+	pending := strings.Join(terms, " OR ")
+	if len(terms) > 1 {
+		pending = "(" + pending + ")"
+	}
+	return "(" + query + ") " + pending
+}
+
+// resolveLabelIDs maps label names or IDs, case insensitively, to IDs.
+func resolveLabelIDs(labels []*gmail.Label, names []string) ([]string, error) {
+	var ids []string
+	for _, name := range names {
+		var found *gmail.Label
+		for _, label := range labels {
+			if label.Id == name || strings.EqualFold(label.Name, name) {
+				found = label
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("unknown label %q; see gmail_list_labels, or create it with gmail_create_label", name)
+		}
+		ids = append(ids, found.Id)
+	}
+	return ids, nil
+}
+
+// stringList reads an optional array of strings argument.
+func stringList(arg interface{}, name string) ([]string, error) {
+	if arg == nil {
+		return nil, nil
+	}
+	items, ok := arg.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of strings", name)
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok || s == "" {
+			return nil, errors.New(name + " must contain non-empty strings")
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (r labelListResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Found %d labels:\n\n", len(r.Labels)))
+	for _, label := range r.Labels {
+		result.WriteString(fmt.Sprintf("%s (ID: %s, %s)\n", label.Name, label.ID, label.Type))
+	}
+	return result.String()
+}
+
+func (r labelListResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**Found %d labels**\n\n| Name | ID | Type |\n|---|---|---|\n", len(r.Labels)))
+	for _, label := range r.Labels {
+		result.WriteString(fmt.Sprintf("| %s | `%s` | %s |\n", markdownCell(label.Name), label.ID, label.Type))
+	}
+	return result.String()
+}
+
+func (l labelInfo) Text() string {
+	return fmt.Sprintf("Label %s created (ID: %s).", l.Name, l.ID)
+}
+
+func (l labelInfo) Markdown() string {
+	return fmt.Sprintf("Label **%s** created (`%s`).", markdownCell(l.Name), l.ID)
+}
+
+func (r modifyLabelsResult) Text() string {
+	text := fmt.Sprintf("%d emails %s.", len(r.MessageIDs), r.Action)
+	if r.More {
+		text += fmt.Sprintf(" More emails match the query; continue with the query %q.", r.NextQuery)
+	}
+	return text
+}
+
+func (r modifyLabelsResult) Markdown() string {
+	return "**" + r.Text() + "**"
+}
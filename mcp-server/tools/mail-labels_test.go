@@ -0,0 +1,25 @@
+package tools
+
+import "testing"
+
+func TestPendingQuery(t *testing.T) {
+	names := map[string]string{"Label_1": "Clients/Acme Corp"}
+	tests := []struct {
+		name        string
+		add, remove []string
+		want        string
+	}{
+		{"star", []string{"STARRED"}, nil, "(from:bob) -is:starred"},
+		{"mark unread", []string{"UNREAD"}, nil, "(from:bob) -is:unread"},
+		{"archive", nil, []string{"INBOX"}, "(from:bob) in:inbox"},
+		{"untrash", nil, []string{"TRASH"}, "(from:bob) in:trash"},
+		{"mark spam", []string{"SPAM"}, []string{"INBOX"}, "(from:bob) (-in:spam OR in:inbox)"},
+		{"user label", []string{"Label_1"}, nil, "(from:bob) -label:clients-acme-corp"},
+		{"category", nil, []string{"CATEGORY_PROMOTIONS"}, "(from:bob) category:promotions"},
+	}
+	for _, tt := range tests {
+		if got := pendingQuery("from:bob", tt.add, tt.remove, names); got != tt.want {
+			t.Errorf("%s: pendingQuery = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+const (
+	// maxMergeRows bounds the recipients of one mail merge.
+	maxMergeRows = 500
+	// defaultMergeDelay spaces out sends so Gmail does not throttle them.
+	defaultMergeDelay = time.Second
+	maxMergeDelay     = time.Minute
+)
+
+// Mail merge modes.
+const (
+	mergeModeSend  = "send"
+	mergeModeDraft = "draft"
+)
+
+// Row statuses of a mail merge.
+const (
+	mergeStatusReady      = "ready"
+	mergeStatusSent       = "sent"
+	mergeStatusDrafted    = "drafted"
+	mergeStatusDryRun     = "dry run"
+	mergeStatusDone       = "already done"
+	mergeStatusClaimed    = "in progress"
+	mergeStatusFailed     = "failed"
+	mergeStatusNotStarted = "not attempted"
+)
+
+// placeholder matches {{column}} in merge templates.
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// mergeIDPattern restricts caller-supplied merge IDs, which name files.
+var mergeIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type mergeRow struct {
+	Row       int    `json:"row" desc:"Data row number, starting at 1 below the header"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body,omitempty" desc:"Rendered body; only in previews"`
+	Status    string `json:"status" desc:"ready, sent, drafted, dry run, already done, in progress, failed or not attempted"`
+	MessageID string `json:"message_id,omitempty" desc:"Sent message or draft ID"`
+	Error     string `json:"error,omitempty"`
+}
+
+type mergeResult struct {
+	MergeID string     `json:"merge_id" desc:"Identifies the merge, in the preview as in the merge; recipients already sent or drafted to under it in the same mode are skipped when run again"`
+	Mode    string     `json:"mode" desc:"preview, send or draft"`
+	Rows    []mergeRow `json:"rows"`
+	Done    int        `json:"done" desc:"Rows sent or drafted, now or by an earlier run"`
+	Failed  int        `json:"failed"`
+	Pending int        `json:"pending" desc:"Rows not attempted yet; run again to continue"`
+}
+
+// mergeJob records, for one merge of one mailbox in one mode, the
+// recipients already sent or drafted to and the resulting message IDs, so
+// an interrupted or corrected merge can be run again without repeating
+// them. It is kept in a file under MAIL_MERGE_DIR; recipients are stored
+// as hashes.
+type mergeJob struct {
+	path    string
+	sent    map[string]string
+	claimed map[string]bool
+}
+
+// Open merge jobs by file. mergeMu guards them all.
+var (
+	mergeMu   sync.Mutex
+	mergeJobs = make(map[string]*mergeJob)
+)
+
+// mergeParams are shared by the preview and the merge itself.
+var mergeParams = []mcp.ToolOption{
+	mcp.WithString("csv", mcp.Description("CSV content with a header row, instead of csv_path")),
+	mcp.WithString("csv_path", mcp.Description("CSV file relative to ATTACHMENT_DIR")),
+	mcp.WithString("to_column", mcp.Description("Column holding the recipient address (default \"to\")")),
+	mcp.WithString("subject", mcp.Description("Subject template with {{column}} placeholders (default \"{{subject}}\")")),
+	mcp.WithString("body", mcp.Description("Plain text body template with {{column}} placeholders (default \"{{body}}\")")),
+	mcp.WithString("html_body", mcp.Description("Optional HTML body template; values are HTML escaped")),
+	mcp.WithString("merge_id", mcp.Description("Names the merge, so running it again skips recipients already done even after "+
+		"the CSV or templates changed (default: derived from the templates)")),
+	outputFormatParam,
+}
+
+func registerMergeTools(s ToolAdder) {
+	// Mail merge preview tool
+	previewTool := mcp.NewTool("gmail_mail_merge_preview", append([]mcp.ToolOption{
+		mcp.WithDescription("Render a mail merge without sending: one email per CSV row, with {{column}} placeholders " +
+			"filled in. Check the result before calling gmail_mail_merge with the same arguments"),
+		readOnlyAnnotation,
+	}, mergeParams...)...)
+	s.AddTool(previewTool, utils.ErrorGuard(gmailMailMergePreviewHandler))
+
+	// Mail merge tool
+	mergeTool := mcp.NewTool("gmail_mail_merge", append([]mcp.ToolOption{
+		mcp.WithDescription("Send, or save as drafts, one email per CSV row, with {{column}} placeholders filled in. " +
+			"Reports the status of every row; running it again with the same merge_id skips recipients already done"),
+		mcp.WithString("mode", mcp.Required(), mcp.Description("send or draft"), mcp.Enum(mergeModeSend, mergeModeDraft)),
+		mcp.WithNumber("delay_ms", mcp.Description(fmt.Sprintf("Pause between emails in milliseconds (default %d, at most %d)",
+			defaultMergeDelay.Milliseconds(), maxMergeDelay.Milliseconds()))),
+		mcp.WithBoolean("continue_on_error", mcp.Description("Keep going after a row fails instead of stopping")),
+	}, mergeParams...)...)
+	s.AddTool(mergeTool, utils.ErrorGuard(gmailMailMergeHandler))
+}
+
+// mailMerge is a parsed CSV with its templates.
+type mailMerge struct {
+	header   []string
+	rows     [][]string
+	toColumn int
+	subject  string
+	body     string
+	html     string
+	id       string
+}
+
+// parseMailMerge reads the CSV and templates of a merge request and checks
+// that every placeholder names a column.
+func parseMailMerge(request mcp.CallToolRequest) (*mailMerge, error) {
+	args := request.Params.Arguments
+	inline, _ := args["csv"].(string)
+	path, _ := args["csv_path"].(string)
+	var data []byte
+	switch {
+	case inline != "" && path != "":
+		return nil, errors.New("give either csv or csv_path, not both")
+	case path != "":
+		var err error
+		if data, err = readAttachmentFile(path); err != nil {
+			return nil, fmt.Errorf("csv_path: %w", err)
+		}
+	case inline != "":
+		data = []byte(inline)
+	default:
+		return nil, errors.New("csv or csv_path is required")
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	m := &mailMerge{header: header}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(m.rows) == maxMergeRows {
+			return nil, fmt.Errorf("CSV has more than %d rows; split it", maxMergeRows)
+		}
+		m.rows = append(m.rows, record)
+	}
+	if len(m.rows) == 0 {
+		return nil, errors.New("CSV has no rows below the header")
+	}
+
+	toColumn, _ := args["to_column"].(string)
+	if toColumn == "" {
+		toColumn = "to"
+	}
+	if m.toColumn = m.column(toColumn); m.toColumn < 0 {
+		return nil, fmt.Errorf("CSV has no %q column; columns are %s", toColumn, strings.Join(header, ", "))
+	}
+	m.subject, _ = args["subject"].(string)
+	if m.subject == "" {
+		m.subject = "{{subject}}"
+	}
+	m.body, _ = args["body"].(string)
+	m.html, _ = args["html_body"].(string)
+	if m.body == "" && m.html == "" {
+		m.body = "{{body}}"
+	}
+	for _, template := range []string{m.subject, m.body, m.html} {
+		for _, match := range placeholder.FindAllStringSubmatch(template, -1) {
+			if m.column(match[1]) < 0 {
+				return nil, fmt.Errorf("placeholder {{%s}} does not match a column; columns are %s", match[1], strings.Join(header, ", "))
+			}
+		}
+	}
+
+	// Without a merge_id, the same templates are the same merge, so fixing
+	// a row of the CSV does not resend the others, and the preview reports
+	// the ID the merge runs under.
+	m.id, _ = args["merge_id"].(string)
+	if m.id != "" && !mergeIDPattern.MatchString(m.id) {
+		return nil, errors.New("merge_id must be up to 64 letters, digits, '.', '_' or '-'")
+	}
+	if m.id == "" {
+		sum := sha256.New()
+		for _, part := range []string{toColumn, m.subject, m.body, m.html} {
+			sum.Write([]byte(part))
+			sum.Write([]byte{0})
+		}
+		m.id = hex.EncodeToString(sum.Sum(nil))[:16]
+	}
+	return m, nil
+}
+
+// column returns the index of the named column, ignoring case, or -1.
+func (m *mailMerge) column(name string) int {
+	for i, h := range m.header {
+		if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// render fills in the templates for row i.
+func (m *mailMerge) render(i int) (mergeRow, *services.Email, error) {
+	record := m.rows[i]
+	fill := func(template string, escape func(string) string) string {
+		return placeholder.ReplaceAllStringFunc(template, func(match string) string {
+			c := m.column(placeholder.FindStringSubmatch(match)[1])
+			if c >= len(record) {
+				return ""
+			}
+			return escape(record[c])
+		})
+	}
+	plain := func(s string) string { return s }
+
+	row := mergeRow{Row: i + 1, Status: mergeStatusReady}
+	if m.toColumn < len(record) {
+		row.To = strings.TrimSpace(record[m.toColumn])
+	}
+	// Values must not add headers through the subject.
+	row.Subject = strings.Join(strings.Fields(fill(m.subject, plain)), " ")
+	row.Body = fill(m.body, plain)
+
+	addrs, err := services.ParseAddressList(row.To)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no recipient")
+	}
+	if err != nil {
+		return row, nil, err
+	}
+	email := &services.Email{
+		To:      addressStrings(addrs),
+		Subject: row.Subject,
+		Text:    row.Body,
+		HTML:    fill(m.html, htmlEscaper.Replace),
+	}
+	return row, email, nil
+}
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;")
+
+func gmailMailMergePreviewHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	m, err := parseMailMerge(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	out := mergeResult{MergeID: m.id, Mode: "preview", Rows: []mergeRow{}}
+	for i := range m.rows {
+		row, _, err := m.render(i)
+		if err != nil {
+			row.Status, row.Error = mergeStatusFailed, err.Error()
+			out.Failed++
+		} else {
+			out.Pending++
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	return structuredResult("gmail_mail_merge_preview", format, out), nil
+}
+
+func gmailMailMergeHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	mode, _ := request.Params.Arguments["mode"].(string)
+	if mode != mergeModeSend && mode != mergeModeDraft {
+		return mcp.NewToolResultError("mode must be send or draft"), nil
+	}
+	delay := time.Duration(mcp.ParseInt64(request, "delay_ms", defaultMergeDelay.Milliseconds())) * time.Millisecond
+	if delay < 0 || delay > maxMergeDelay {
+		return mcp.NewToolResultError(fmt.Sprintf("delay_ms must be between 0 and %d", maxMergeDelay.Milliseconds())), nil
+	}
+	continueOnError, _ := request.Params.Arguments["continue_on_error"].(bool)
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	m, err := parseMailMerge(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	account, err := mailboxFor(ctx, srv)
+	if err != nil {
+		return googleErrorResult("get Gmail profile", err), nil
+	}
+	job, err := openMergeJob(account, m.id, mode)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to open mail merge record: %v", err)), nil
+	}
+
+	out := mergeResult{MergeID: m.id, Mode: mode, Rows: []mergeRow{}}
+	stopped := false
+	first := true
+	for i := range m.rows {
+		row, email, err := m.render(i)
+		row.Body = ""
+		if err != nil {
+			if stopped {
+				row.Status = mergeStatusNotStarted
+				out.Pending++
+			} else {
+				row.Status, row.Error = mergeStatusFailed, err.Error()
+				out.Failed++
+				stopped = !continueOnError
+			}
+			out.Rows = append(out.Rows, row)
+			continue
+		}
+
+		recipient := mergeRecipient(row.To)
+		switch done, claimed := job.claim(recipient, !stopped); {
+		case done != "":
+			row.Status, row.MessageID = mergeStatusDone, done
+			out.Done++
+		case stopped:
+			row.Status = mergeStatusNotStarted
+			out.Pending++
+		case !claimed:
+			// Another run of the same merge is sending to this recipient.
+			row.Status = mergeStatusClaimed
+			out.Pending++
+		default:
+			if !first {
+				select {
+				case <-ctx.Done():
+					job.release(recipient)
+					stopped = true
+					row.Status = mergeStatusNotStarted
+					out.Pending++
+					out.Rows = append(out.Rows, row)
+					continue
+				case <-time.After(delay):
+				}
+			}
+			first = false
+			err := mergeOne(ctx, srv, mode, email, &row)
+			if err != nil {
+				job.release(recipient)
+				row.Status, row.Error = mergeStatusFailed, services.ClassifyGoogleError(err).Error()
+				out.Failed++
+				// A used up quota fails every following row too.
+				stopped = !continueOnError || errors.Is(err, errSendQuota)
+			} else if row.Status == mergeStatusDryRun {
+				job.release(recipient)
+			} else {
+				out.Done++
+				if err := job.record(recipient, row.MessageID); err != nil {
+					row.Error = fmt.Sprintf("done, but not recorded; a rerun would repeat it: %v", err)
+				}
+			}
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	return structuredResult("gmail_mail_merge", format, out), nil
+}
+
+// mergeOne sends or drafts email for row.
+func mergeOne(ctx context.Context, srv *gmail.Service, mode string, email *services.Email, row *mergeRow) error {
+	if mode == mergeModeDraft {
+		msg, err := rawMessage(email, "")
+		if err != nil {
+			return err
+		}
+		draft, err := srv.Users.Drafts.Create("me", &gmail.Draft{Message: msg}).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		row.Status, row.MessageID = mergeStatusDrafted, draft.Id
+	} else {
+		sent, err := sendEmail(ctx, srv, email, "")
+		if err != nil {
+			return err
+		}
+		if sent.DryRun {
+			row.Status = mergeStatusDryRun
+			return nil
+		}
+		row.Status, row.MessageID = mergeStatusSent, sent.ID
+	}
+	return nil
+}
+
+// mergeDir reads MAIL_MERGE_DIR, which defaults to mail-merge in the
+// working directory.
+func mergeDir() string {
+	if dir := os.Getenv("MAIL_MERGE_DIR"); dir != "" {
+		return dir
+	}
+	pwd, _ := os.Getwd()
+	return filepath.Join(pwd, "mail-merge")
+}
+
+// openMergeJob returns the record of a merge of account in mode, reading it
+// from disk the first time. Drafting a merge does not count as sending it.
+func openMergeJob(account, mergeID, mode string) (*mergeJob, error) {
+	sum := sha256.Sum256([]byte(account))
+	path := filepath.Join(mergeDir(), hex.EncodeToString(sum[:8]), mergeID+"-"+mode+".json")
+
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	if job, ok := mergeJobs[path]; ok {
+		return job, nil
+	}
+	job := &mergeJob{path: path, sent: make(map[string]string), claimed: make(map[string]bool)}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &job.sent); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	mergeJobs[path] = job
+	return job, nil
+}
+
+// mergeRecipient identifies the recipients of a row regardless of case,
+// order and display names.
+func mergeRecipient(to string) string {
+	addrs, _ := services.ParseAddressList(to)
+	var list []string
+	for _, a := range addrs {
+		list = append(list, strings.ToLower(a.Address))
+	}
+	slices.Sort(list)
+	sum := sha256.Sum256([]byte(strings.Join(list, ",")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// claim returns the message ID recorded for recipient, if any. Otherwise,
+// when take is set, it claims recipient for the caller unless another run
+// holds it, and reports whether it did.
+func (j *mergeJob) claim(recipient string, take bool) (done string, claimed bool) {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	if id, ok := j.sent[recipient]; ok {
+		return id, false
+	}
+	if !take || j.claimed[recipient] {
+		return "", false
+	}
+	j.claimed[recipient] = true
+	return "", true
+}
+
+// release gives up a claim on recipient without recording it.
+func (j *mergeJob) release(recipient string) {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	delete(j.claimed, recipient)
+}
+
+// record marks recipient as done with messageID and writes the job to disk.
+func (j *mergeJob) record(recipient, messageID string) error {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	delete(j.claimed, recipient)
+	j.sent[recipient] = messageID
+	b, err := json.Marshal(j.sent)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+func (r mergeResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Mail merge %s (%s): %d done, %d failed, %d pending\n\n", r.MergeID, r.Mode, r.Done, r.Failed, r.Pending))
+	for _, row := range r.Rows {
+		result.WriteString(fmt.Sprintf("Row %d: %s - %s\n", row.Row, row.To, row.Status))
+		result.WriteString(fmt.Sprintf("Subject: %s\n", row.Subject))
+		if row.Error != "" {
+			result.WriteString(fmt.Sprintf("Error: %s\n", row.Error))
+		}
+		if row.Body != "" {
+			result.WriteString(fmt.Sprintf("Body:\n%s\n", row.Body))
+		}
+		result.WriteString("-------------------\n")
+	}
+	if r.Pending > 0 && r.Mode != "preview" {
+		result.WriteString("Run gmail_mail_merge again with the same merge_id to continue.\n")
+	}
+	return result.String()
+}
+
+func (r mergeResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**Mail merge `%s` (%s)**: %d done, %d failed, %d pending\n\n", r.MergeID, r.Mode, r.Done, r.Failed, r.Pending))
+	result.WriteString("| Row | To | Subject | Status |\n|---|---|---|---|\n")
+	for _, row := range r.Rows {
+		status := row.Status
+		if row.Error != "" {
+			status += ": " + row.Error
+		}
+		result.WriteString(fmt.Sprintf("| %d | %s | %s | %s |\n", row.Row, markdownCell(row.To), markdownCell(row.Subject), markdownCell(status)))
+	}
+	if r.Mode == "preview" && len(r.Rows) > 0 && r.Rows[0].Body != "" {
+		result.WriteString(fmt.Sprintf("\nFirst body:\n\n```\n%s\n```\n", r.Rows[0].Body))
+	}
+	return result.String()
+}
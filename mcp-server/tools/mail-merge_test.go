@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestMergeRecipient(t *testing.T) {
+	same := []string{
+		"alice@example.com, bob@example.com",
+		"Bob <BOB@example.com>, \"Alice A.\" <alice@Example.com>",
+	}
+	if a, b := mergeRecipient(same[0]), mergeRecipient(same[1]); a != b {
+		t.Errorf("mergeRecipient differs for %q and %q", same[0], same[1])
+	}
+	if mergeRecipient("alice@example.com") == mergeRecipient("alice@example.com, bob@example.com") {
+		t.Error("mergeRecipient ignores an added recipient")
+	}
+}
+
+func TestMergeJob(t *testing.T) {
+	t.Setenv("MAIL_MERGE_DIR", t.TempDir())
+	job, err := openMergeJob("me@example.com", "newsletter", mergeModeSend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := mergeRecipient("alice@example.com"), mergeRecipient("bob@example.com")
+
+	if done, claimed := job.claim(alice, true); done != "" || !claimed {
+		t.Fatalf("first claim = %q, %v; want it claimed", done, claimed)
+	}
+	if _, claimed := job.claim(alice, true); claimed {
+		t.Error("a recipient was claimed twice")
+	}
+	if err := job.record(alice, "msg-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed := job.claim(bob, true); !claimed {
+		t.Fatal("bob could not be claimed")
+	}
+	job.release(bob)
+
+	// A restart reads the recorded recipients back from disk.
+	mergeMu.Lock()
+	delete(mergeJobs, job.path)
+	mergeMu.Unlock()
+	reopened, err := openMergeJob("me@example.com", "newsletter", mergeModeSend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done, _ := reopened.claim(alice, true); done != "msg-1" {
+		t.Errorf("alice after reopening = %q, want msg-1", done)
+	}
+	if _, claimed := reopened.claim(bob, true); !claimed {
+		t.Error("a released recipient was recorded")
+	}
+
+	// Drafting and other mailboxes keep their own records.
+	for _, other := range []struct{ account, mode string }{
+		{"me@example.com", mergeModeDraft},
+		{"someone@example.com", mergeModeSend},
+	} {
+		job, err := openMergeJob(other.account, "newsletter", other.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done, _ := job.claim(alice, false); done != "" {
+			t.Errorf("%s in %s mode sees alice as done", other.account, other.mode)
+		}
+	}
+}
+
+func TestParseMailMergeID(t *testing.T) {
+	request := func(args map[string]any) mcp.CallToolRequest {
+		var r mcp.CallToolRequest
+		r.Params.Arguments = args
+		return r
+	}
+	first, err := parseMailMerge(request(map[string]any{"csv": "to,subject,body\na@example.com,Hi,Hello"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := parseMailMerge(request(map[string]any{"csv": "to,subject,body\nb@example.com,Hi,Hello\nc@example.com,Hi,Hey"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.id != fixed.id {
+		t.Error("changing the CSV changed the merge ID")
+	}
+	named, err := parseMailMerge(request(map[string]any{"csv": "to,subject,body\na@example.com,Hi,Hello", "merge_id": "spring-2024"}))
+	if err != nil || named.id != "spring-2024" {
+		t.Errorf("merge_id spring-2024 gave %q, %v", named.id, err)
+	}
+	for _, id := range []string{"../escape", ".hidden", "a/b"} {
+		if _, err := parseMailMerge(request(map[string]any{"csv": "to\na@example.com", "merge_id": id})); err == nil {
+			t.Errorf("merge_id %q was accepted", id)
+		}
+	}
+}
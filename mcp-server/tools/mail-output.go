@@ -0,0 +1,236 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// emailSummary is one search hit.
+type emailSummary struct {
+	ID       string   `json:"id"`
+	ThreadID string   `json:"thread_id"`
+	From     string   `json:"from"`
+	Subject  string   `json:"subject"`
+	Date     string   `json:"date" desc:"Date header as sent"`
+	Snippet  string   `json:"snippet"`
+	Labels   []string `json:"labels" desc:"Gmail label IDs"`
+}
+
+type searchResult struct {
+	Messages      []emailSummary `json:"messages"`
+	NextPageToken string         `json:"next_page_token,omitempty" desc:"Pass as page_token to fetch the next page"`
+	Cached        bool           `json:"cached,omitempty" desc:"Set when the local mail cache answered the search"`
+	StaleSince    string         `json:"stale_since,omitempty" desc:"Set when the cache could not be synced with Gmail; results reflect the mailbox at this time, RFC 3339"`
+}
+
+// emailHeaders are the headers shown when reading a message.
+type emailHeaders struct {
+	From    string `json:"from"`
+	To      string `json:"to,omitempty"`
+	Cc      string `json:"cc,omitempty"`
+	Subject string `json:"subject"`
+	Date    string `json:"date"`
+}
+
+type attachmentInfo struct {
+	PartID       string `json:"part_id" desc:"MIME part ID, for gmail_get_attachment"`
+	Filename     string `json:"filename"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size" desc:"Size in bytes"`
+	AttachmentID string `json:"attachment_id,omitempty"`
+}
+
+type emailMessage struct {
+	ID          string           `json:"id"`
+	ThreadID    string           `json:"thread_id"`
+	Headers     emailHeaders     `json:"headers"`
+	Labels      []string         `json:"labels" desc:"Gmail label IDs"`
+	Body        string           `json:"body" desc:"Plain text body, or the HTML body converted to markdown"`
+	BodyFormat  string           `json:"body_format" desc:"text, or markdown when body was converted from HTML"`
+	HTMLBody    string           `json:"html_body,omitempty" desc:"Original HTML body; only present when include_html is set"`
+	Attachments []attachmentInfo `json:"attachments,omitempty" desc:"Only present when include_attachments is set"`
+}
+
+type markReadResult struct {
+	ID     string   `json:"id"`
+	Labels []string `json:"labels" desc:"Gmail label IDs after the change"`
+}
+
+type sendResult struct {
+	ID       string `json:"id"`
+	ThreadID string `json:"thread_id"`
+	DryRun   bool   `json:"dry_run,omitempty" desc:"Set when SEND_DRY_RUN is on and nothing was sent"`
+	MIME     string `json:"mime,omitempty" desc:"The composed message, only in dry-run mode"`
+}
+
+// headerValue returns the first header of part called name.
+func headerValue(part *gmail.MessagePart, name string) string {
+	if part == nil {
+		return ""
+	}
+	for _, header := range part.Headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+func newEmailSummary(message *gmail.Message) emailSummary {
+	return emailSummary{
+		ID:       message.Id,
+		ThreadID: message.ThreadId,
+		From:     headerValue(message.Payload, "From"),
+		Subject:  headerValue(message.Payload, "Subject"),
+		Date:     headerValue(message.Payload, "Date"),
+		Snippet:  message.Snippet,
+		Labels:   nonNil(message.LabelIds),
+	}
+}
+
+func newEmailHeaders(message *gmail.Message) emailHeaders {
+	return emailHeaders{
+		From:    headerValue(message.Payload, "From"),
+		To:      headerValue(message.Payload, "To"),
+		Cc:      headerValue(message.Payload, "Cc"),
+		Subject: headerValue(message.Payload, "Subject"),
+		Date:    headerValue(message.Payload, "Date"),
+	}
+}
+
+// nonNil keeps empty lists from being encoded as null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func (r searchResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Found %d emails:\n\n", len(r.Messages)))
+	if r.StaleSince != "" {
+		result.WriteString(fmt.Sprintf("Gmail could not be reached; these results come from the mail cache as of %s.\n\n", r.StaleSince))
+	}
+	for _, message := range r.Messages {
+		result.WriteString(fmt.Sprintf("Message ID: %s\n", message.ID))
+		result.WriteString(fmt.Sprintf("From: %s\n", message.From))
+		result.WriteString(fmt.Sprintf("Subject: %s\n", message.Subject))
+		result.WriteString(fmt.Sprintf("Date: %s\n", message.Date))
+		result.WriteString(fmt.Sprintf("Snippet: %s\n", message.Snippet))
+		result.WriteString("-------------------\n")
+	}
+	if r.NextPageToken != "" {
+		result.WriteString(fmt.Sprintf("Next page token: %s\n", r.NextPageToken))
+	}
+	return result.String()
+}
+
+func (r searchResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**Found %d emails**\n\n", len(r.Messages)))
+	if r.StaleSince != "" {
+		result.WriteString(fmt.Sprintf("> Gmail could not be reached; these results come from the mail cache as of %s.\n\n", r.StaleSince))
+	}
+	if len(r.Messages) > 0 {
+		result.WriteString("| From | Subject | Date | Message ID |\n|---|---|---|---|\n")
+		for _, message := range r.Messages {
+			result.WriteString(fmt.Sprintf("| %s | %s | %s | `%s` |\n",
+				markdownCell(message.From), markdownCell(message.Subject), markdownCell(message.Date), message.ID))
+		}
+	}
+	if r.NextPageToken != "" {
+		result.WriteString(fmt.Sprintf("\nNext page token: `%s`\n", r.NextPageToken))
+	}
+	return result.String()
+}
+
+func (m emailMessage) Text() string {
+	var result strings.Builder
+	writeHeader := func(name, value string) {
+		if value != "" {
+			result.WriteString(fmt.Sprintf("%s: %s\n", name, value))
+		}
+	}
+	writeHeader("From", m.Headers.From)
+	writeHeader("To", m.Headers.To)
+	writeHeader("Cc", m.Headers.Cc)
+	writeHeader("Subject", m.Headers.Subject)
+	writeHeader("Date", m.Headers.Date)
+	result.WriteString("\n")
+
+	result.WriteString("Body:\n")
+	result.WriteString(m.Body)
+	result.WriteString("\n")
+	if m.HTMLBody != "" {
+		result.WriteString("\nHTML body:\n")
+		result.WriteString(m.HTMLBody)
+		result.WriteString("\n")
+	}
+
+	if len(m.Attachments) > 0 {
+		result.WriteString("\nAttachments:\n")
+		for _, a := range m.Attachments {
+			result.WriteString(fmt.Sprintf("- %s (Size: %d bytes)\n", a.Filename, a.Size))
+		}
+	}
+	return result.String()
+}
+
+func (m emailMessage) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("## %s\n\n", m.Headers.Subject))
+	writeHeader := func(name, value string) {
+		if value != "" {
+			result.WriteString(fmt.Sprintf("- **%s:** %s\n", name, value))
+		}
+	}
+	writeHeader("From", m.Headers.From)
+	writeHeader("To", m.Headers.To)
+	writeHeader("Cc", m.Headers.Cc)
+	writeHeader("Date", m.Headers.Date)
+	result.WriteString("\n")
+	result.WriteString(m.Body)
+	result.WriteString("\n")
+	if m.HTMLBody != "" {
+		result.WriteString("\n### HTML body\n\n```html\n" + m.HTMLBody + "\n```\n")
+	}
+
+	if len(m.Attachments) > 0 {
+		result.WriteString("\n### Attachments\n\n")
+		for _, a := range m.Attachments {
+			result.WriteString(fmt.Sprintf("- %s (%s, %d bytes)\n", a.Filename, a.MimeType, a.Size))
+		}
+	}
+	return result.String()
+}
+
+func (r markReadResult) Text() string {
+	return "Email marked as read."
+}
+
+func (r markReadResult) Markdown() string {
+	return fmt.Sprintf("Email `%s` marked as read.", r.ID)
+}
+
+func (r sendResult) Text() string {
+	if r.DryRun {
+		return "Dry run, the email was not sent. Composed message:\n\n" + r.MIME
+	}
+	return "Email sent successfully."
+}
+
+func (r sendResult) Markdown() string {
+	if r.DryRun {
+		return "**Dry run**, the email was not sent. Composed message:\n\n```\n" + r.MIME + "```\n"
+	}
+	return fmt.Sprintf("Email sent successfully (message `%s`).", r.ID)
+}
+
+// markdownCell escapes text for use in a markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,276 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+var (
+	replyPrefix   = regexp.MustCompile(`(?i)^re(\[\d+\])?:`)
+	forwardPrefix = regexp.MustCompile(`(?i)^(fwd?|fw):`)
+)
+
+func gmailReplyHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	messageID, ok := request.Params.Arguments["message_id"].(string)
+	if !ok || messageID == "" {
+		return mcp.NewToolResultError("message_id must be a non-empty string"), nil
+	}
+	body, ok := request.Params.Arguments["body"].(string)
+	if !ok {
+		return mcp.NewToolResultError("body must be a string"), nil
+	}
+	replyAll, _ := request.Params.Arguments["reply_all"].(bool)
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	original, err := srv.Users.Messages.Get("me", messageID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get email", err), nil
+	}
+	profile, err := srv.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get account address", err), nil
+	}
+
+	to, cc := replyRecipients(original.Payload, profile.EmailAddress, replyAll)
+	if len(to) == 0 {
+		return mcp.NewToolResultError("the original email has no sender to reply to"), nil
+	}
+	email := &services.Email{
+		To:      to,
+		Cc:      cc,
+		Subject: prefixSubject(headerValue(original.Payload, "Subject"), "Re: ", replyPrefix),
+		Text:    body + "\n\n" + quoteBody(original),
+	}
+	threadUnder(email, original.Payload)
+
+	sent, err := sendEmail(ctx, srv, email, original.ThreadId)
+	if err != nil {
+		return googleErrorResult("send reply", err), nil
+	}
+	return structuredResult("gmail_reply", format, sent), nil
+}
+
+func gmailForwardHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	messageID, ok := request.Params.Arguments["message_id"].(string)
+	if !ok || messageID == "" {
+		return mcp.NewToolResultError("message_id must be a non-empty string"), nil
+	}
+	to, ok := request.Params.Arguments["to"].(string)
+	if !ok || to == "" {
+		return mcp.NewToolResultError("to must be a non-empty string"), nil
+	}
+	note, _ := request.Params.Arguments["body"].(string)
+	includeAttachments := true
+	if v, ok := request.Params.Arguments["include_attachments"].(bool); ok {
+		includeAttachments = v
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	original, err := srv.Users.Messages.Get("me", messageID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get email", err), nil
+	}
+
+	recipients, err := services.ParseAddressList(to)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("invalid to: %v", err)), nil
+	}
+	email := &services.Email{
+		To:      addressStrings(recipients),
+		Subject: prefixSubject(headerValue(original.Payload, "Subject"), "Fwd: ", forwardPrefix),
+		Text:    note + "\n\n" + forwardedBody(original),
+	}
+	threadUnder(email, original.Payload)
+	if includeAttachments {
+		email.Attachments, err = messageAttachments(ctx, srv, original)
+		if err != nil {
+			return googleErrorResult("download attachments", err), nil
+		}
+	}
+
+	sent, err := sendEmail(ctx, srv, email, original.ThreadId)
+	if err != nil {
+		return googleErrorResult("forward email", err), nil
+	}
+	return structuredResult("gmail_forward", format, sent), nil
+}
+
+// sendEmail sends email in threadID, or in a new thread when threadID is "",
+// subject to the send policy.
+func sendEmail(ctx context.Context, srv *gmail.Service, email *services.Email, threadID string) (sendResult, error) {
+	recipients, err := email.Recipients()
+	if err != nil {
+		return sendResult{}, err
+	}
+	raw, err := email.Bytes()
+	if err != nil {
+		return sendResult{}, err
+	}
+	return sendUnderPolicy(ctx, recipients, raw, func() (*gmail.Message, error) {
+		msg := &gmail.Message{Raw: base64.URLEncoding.EncodeToString(raw), ThreadId: threadID}
+		return srv.Users.Messages.Send("me", msg).Context(ctx).Do()
+	})
+}
+
+// rawMessage encodes email for the Gmail API.
+func rawMessage(email *services.Email, threadID string) (*gmail.Message, error) {
+	raw, err := email.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.Message{
+		Raw:      base64.URLEncoding.EncodeToString(raw),
+		ThreadId: threadID,
+	}, nil
+}
+
+// threadUnder sets the In-Reply-To and References headers of email so it
+// follows the message with headers original.
+func threadUnder(email *services.Email, original *gmail.MessagePart) {
+	messageID := headerValue(original, "Message-ID")
+	if messageID == "" {
+		return
+	}
+	email.InReplyTo = messageID
+	email.References = strings.TrimSpace(headerValue(original, "References") + " " + messageID)
+}
+
+// prefixSubject adds prefix to subject unless it already carries one that
+// matches existing.
+func prefixSubject(subject, prefix string, existing *regexp.Regexp) string {
+	if existing.MatchString(strings.TrimSpace(subject)) {
+		return subject
+	}
+	return prefix + subject
+}
+
+// replyRecipients answers the Reply-To, else From, of the original. With
+// all set, the original To and Cc are copied too. self is never included.
+func replyRecipients(original *gmail.MessagePart, self string, all bool) (to, cc []string) {
+	seen := map[string]bool{strings.ToLower(self): true}
+	add := func(list []string, header string) []string {
+		for _, addr := range splitAddresses(header) {
+			key := strings.ToLower(addr)
+			if parsed, err := mail.ParseAddress(addr); err == nil {
+				key = strings.ToLower(parsed.Address)
+			}
+			if !seen[key] {
+				seen[key] = true
+				list = append(list, addr)
+			}
+		}
+		return list
+	}
+
+	sender := headerValue(original, "Reply-To")
+	if sender == "" {
+		sender = headerValue(original, "From")
+	}
+	to = add(to, sender)
+	// Replying to one's own message goes back to its recipients.
+	if len(to) == 0 || all {
+		to = add(to, headerValue(original, "To"))
+	}
+	if all {
+		cc = add(cc, headerValue(original, "Cc"))
+	}
+	return to, cc
+}
+
+// splitAddresses splits an address list header, keeping display names.
+func splitAddresses(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+	list, err := mail.ParseAddressList(header)
+	if err != nil {
+		var out []string
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				out = append(out, addr)
+			}
+		}
+		return out
+	}
+	return addressStrings(list)
+}
+
+func addressStrings(list []*mail.Address) []string {
+	out := make([]string, len(list))
+	for i, addr := range list {
+		out[i] = addr.String()
+	}
+	return out
+}
+
+// quoteBody renders the original body as a ">" quoted reply.
+func quoteBody(original *gmail.Message) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("On %s, %s wrote:\n",
+		headerValue(original.Payload, "Date"), headerValue(original.Payload, "From")))
+	body := strings.ReplaceAll(extractMessageBody(original.Payload), "\r\n", "\n")
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		if line == "" {
+			b.WriteString(">\n")
+		} else {
+			b.WriteString("> " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// forwardedBody renders the original message the way mail clients do below
+// a forward.
+func forwardedBody(original *gmail.Message) string {
+	var b strings.Builder
+	b.WriteString("---------- Forwarded message ---------\n")
+	for _, name := range []string{"From", "Date", "Subject", "To", "Cc"} {
+		if value := headerValue(original.Payload, name); value != "" {
+			b.WriteString(fmt.Sprintf("%s: %s\n", name, value))
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(extractMessageBody(original.Payload))
+	return b.String()
+}
+
+// messageAttachments downloads every attachment of message.
+func messageAttachments(ctx context.Context, srv *gmail.Service, message *gmail.Message) ([]services.Attachment, error) {
+	var attachments []services.Attachment
+	for _, part := range attachmentParts(message.Payload) {
+		data, err := attachmentData(ctx, srv, message.Id, part)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, services.Attachment{
+			Filename:    part.Filename,
+			ContentType: part.MimeType,
+			Data:        data,
+		})
+	}
+	return attachments, nil
+}
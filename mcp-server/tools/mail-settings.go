@@ -0,0 +1,571 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+type filterCriteria struct {
+	From          string `json:"from,omitempty"`
+	To            string `json:"to,omitempty"`
+	Subject       string `json:"subject,omitempty"`
+	Query         string `json:"query,omitempty" desc:"Gmail search query the message must match"`
+	NegatedQuery  string `json:"negated_query,omitempty" desc:"Gmail search query the message must not match"`
+	HasAttachment bool   `json:"has_attachment,omitempty"`
+}
+
+type filterAction struct {
+	AddLabels    []string `json:"add_labels,omitempty" desc:"Label names"`
+	RemoveLabels []string `json:"remove_labels,omitempty" desc:"Label names"`
+	Forward      string   `json:"forward,omitempty" desc:"Address messages are forwarded to"`
+}
+
+type filterInfo struct {
+	ID       string         `json:"id"`
+	Criteria filterCriteria `json:"criteria"`
+	Action   filterAction   `json:"action"`
+}
+
+type filterListResult struct {
+	Filters []filterInfo `json:"filters"`
+}
+
+type deleteFilterResult struct {
+	ID string `json:"id"`
+}
+
+type vacationResult struct {
+	Enabled      bool   `json:"enabled"`
+	Subject      string `json:"subject,omitempty"`
+	Body         string `json:"body,omitempty" desc:"Plain text reply"`
+	HTMLBody     string `json:"html_body,omitempty" desc:"HTML reply, used instead of body when set"`
+	ContactsOnly bool   `json:"contacts_only" desc:"Only reply to people in the user's contacts"`
+	DomainOnly   bool   `json:"domain_only" desc:"Only reply to people in the user's Workspace domain"`
+	StartTime    string `json:"start_time,omitempty" desc:"RFC 3339"`
+	EndTime      string `json:"end_time,omitempty" desc:"RFC 3339"`
+}
+
+type sendAsAlias struct {
+	Email              string `json:"email"`
+	DisplayName        string `json:"display_name,omitempty"`
+	ReplyTo            string `json:"reply_to,omitempty"`
+	IsPrimary          bool   `json:"is_primary"`
+	IsDefault          bool   `json:"is_default" desc:"Used when no from address is given"`
+	VerificationStatus string `json:"verification_status,omitempty"`
+	Signature          string `json:"signature,omitempty" desc:"Signature as markdown"`
+}
+
+type sendAsListResult struct {
+	Aliases []sendAsAlias `json:"aliases"`
+}
+
+// filterLabelActions are the filter shortcuts that translate to label
+// changes, as in the Gmail filter dialog.
+var filterLabelActions = []struct {
+	arg, description string
+	add, remove      string
+}{
+	{"archive", "Skip the inbox", "", "INBOX"},
+	{"mark_read", "Mark as read", "", "UNREAD"},
+	{"star", "Star it", "STARRED", ""},
+	{"trash", "Delete it (move to the trash)", "TRASH", ""},
+	{"mark_important", "Always mark it as important", "IMPORTANT", ""},
+	{"never_spam", "Never send it to spam", "", "SPAM"},
+}
+
+func registerSettingsTools(s ToolAdder) {
+	// List filters tool
+	listFiltersTool := mcp.NewTool("gmail_list_filters",
+		mcp.WithDescription("List the Gmail filters (inbox rules) of the account with their criteria and actions"),
+		readOnlyAnnotation,
+		outputFormatParam,
+	)
+	s.AddTool(listFiltersTool, utils.ErrorGuard(gmailListFiltersHandler))
+
+	// Create filter tool
+	createFilterOpts := []mcp.ToolOption{
+		mcp.WithDescription("Create a Gmail filter that acts on incoming mail matching its criteria. " +
+			"Give at least one criterion and one action"),
+		mcp.WithString("from", mcp.Description("Sender name or address")),
+		mcp.WithString("to", mcp.Description("Recipient name or address, including Cc and Bcc")),
+		mcp.WithString("subject", mcp.Description("Phrase in the subject")),
+		mcp.WithString("query", mcp.Description("Gmail search query the message must match")),
+		mcp.WithString("negated_query", mcp.Description("Gmail search query the message must not match")),
+		mcp.WithBoolean("has_attachment", mcp.Description("Only messages with attachments")),
+		mcp.WithArray("add_labels", mcp.Description("Labels to apply, by name or ID"), mcp.Items(map[string]interface{}{"type": "string"})),
+		mcp.WithString("forward_to", mcp.Description("Forward matching mail to this address; it must be a verified forwarding address")),
+		outputFormatParam,
+	}
+	for _, a := range filterLabelActions {
+		createFilterOpts = append(createFilterOpts, mcp.WithBoolean(a.arg, mcp.Description(a.description)))
+	}
+	createFilterTool := mcp.NewTool("gmail_create_filter", createFilterOpts...)
+	s.AddTool(createFilterTool, utils.ErrorGuard(gmailCreateFilterHandler))
+
+	// Delete filter tool
+	deleteFilterTool := mcp.NewTool("gmail_delete_filter",
+		mcp.WithDescription("Delete a Gmail filter. Mail it already acted on is not changed"),
+		mcp.WithString("filter_id", mcp.Required(), mcp.Description("ID of the filter, from gmail_list_filters")),
+		outputFormatParam,
+	)
+	s.AddTool(deleteFilterTool, utils.ErrorGuard(gmailDeleteFilterHandler))
+
+	// Get vacation tool
+	getVacationTool := mcp.NewTool("gmail_get_vacation",
+		mcp.WithDescription("Show the vacation auto-reply settings"),
+		readOnlyAnnotation,
+		outputFormatParam,
+	)
+	s.AddTool(getVacationTool, utils.ErrorGuard(gmailGetVacationHandler))
+
+	// Set vacation tool
+	setVacationTool := mcp.NewTool("gmail_set_vacation",
+		mcp.WithDescription("Turn the vacation auto-reply on or off and change its message. Settings not given are kept"),
+		mcp.WithBoolean("enabled", mcp.Required(), mcp.Description("Whether Gmail sends auto-replies")),
+		mcp.WithString("subject", mcp.Description("Subject of the auto-reply")),
+		mcp.WithString("body", mcp.Description("Plain text auto-reply")),
+		mcp.WithString("html_body", mcp.Description("HTML auto-reply, used instead of body")),
+		mcp.WithBoolean("contacts_only", mcp.Description("Only reply to people in the user's contacts")),
+		mcp.WithBoolean("domain_only", mcp.Description("Only reply to people in the user's Workspace domain")),
+		mcp.WithString("start_time", mcp.Description("When to start replying, RFC 3339 or YYYY-MM-DD; empty for now")),
+		mcp.WithString("end_time", mcp.Description("When to stop replying, RFC 3339 or YYYY-MM-DD; empty for never")),
+		outputFormatParam,
+	)
+	s.AddTool(setVacationTool, utils.ErrorGuard(gmailSetVacationHandler))
+
+	// List send-as tool
+	listSendAsTool := mcp.NewTool("gmail_list_send_as",
+		mcp.WithDescription("List the addresses the account can send as, usable as from when sending, with their signatures"),
+		readOnlyAnnotation,
+		outputFormatParam,
+	)
+	s.AddTool(listSendAsTool, utils.ErrorGuard(gmailListSendAsHandler))
+}
+
+func gmailListFiltersHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	resp, err := srv.Users.Settings.Filters.List("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("list filters", err), nil
+	}
+	labels, err := srv.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("list labels", err), nil
+	}
+	out := filterListResult{Filters: []filterInfo{}}
+	for _, filter := range resp.Filter {
+		out.Filters = append(out.Filters, newFilterInfo(filter, labels.Labels))
+	}
+	return structuredResult("gmail_list_filters", format, out), nil
+}
+
+func gmailCreateFilterHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	args := request.Params.Arguments
+	criteria := &gmail.FilterCriteria{}
+	criteria.From, _ = args["from"].(string)
+	criteria.To, _ = args["to"].(string)
+	criteria.Subject, _ = args["subject"].(string)
+	criteria.Query, _ = args["query"].(string)
+	criteria.NegatedQuery, _ = args["negated_query"].(string)
+	criteria.HasAttachment, _ = args["has_attachment"].(bool)
+	if criteria.From == "" && criteria.To == "" && criteria.Subject == "" && criteria.Query == "" &&
+		criteria.NegatedQuery == "" && !criteria.HasAttachment {
+		return mcp.NewToolResultError("give at least one of from, to, subject, query, negated_query or has_attachment"), nil
+	}
+
+	addLabels, err := stringList(args["add_labels"], "add_labels")
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	action := &gmail.FilterAction{}
+	action.Forward, _ = args["forward_to"].(string)
+	if action.Forward != "" {
+		if err := checkForwardTo(action.Forward); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+	}
+	for _, a := range filterLabelActions {
+		if on, _ := args[a.arg].(bool); !on {
+			continue
+		}
+		if a.add != "" {
+			action.AddLabelIds = append(action.AddLabelIds, a.add)
+		}
+		if a.remove != "" {
+			action.RemoveLabelIds = append(action.RemoveLabelIds, a.remove)
+		}
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	labels, err := srv.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("list labels", err), nil
+	}
+	ids, err := resolveLabelIDs(labels.Labels, addLabels)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	action.AddLabelIds = append(action.AddLabelIds, ids...)
+	if len(action.AddLabelIds) == 0 && len(action.RemoveLabelIds) == 0 && action.Forward == "" {
+		return mcp.NewToolResultError("give at least one action: add_labels, forward_to or a shortcut such as archive"), nil
+	}
+
+	filter, err := srv.Users.Settings.Filters.Create("me", &gmail.Filter{Criteria: criteria, Action: action}).Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("create filter", err), nil
+	}
+	return structuredResult("gmail_create_filter", format, newFilterInfo(filter, labels.Labels)), nil
+}
+
+func gmailDeleteFilterHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	filterID, ok := request.Params.Arguments["filter_id"].(string)
+	if !ok || filterID == "" {
+		return mcp.NewToolResultError("filter_id must be a non-empty string"), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	if err := srv.Users.Settings.Filters.Delete("me", filterID).Context(ctx).Do(); err != nil {
+		return googleErrorResult("delete filter", err), nil
+	}
+	return structuredResult("gmail_delete_filter", format, deleteFilterResult{ID: filterID}), nil
+}
+
+func gmailGetVacationHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	vacation, err := srv.Users.Settings.GetVacation("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get vacation settings", err), nil
+	}
+	return structuredResult("gmail_get_vacation", format, newVacationResult(vacation)), nil
+}
+
+func gmailSetVacationHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	args := request.Params.Arguments
+	enabled, ok := args["enabled"].(bool)
+	if !ok {
+		return mcp.NewToolResultError("enabled must be a boolean"), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	// Start from the current settings so arguments left out are kept.
+	vacation, err := srv.Users.Settings.GetVacation("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get vacation settings", err), nil
+	}
+	vacation.EnableAutoReply = enabled
+	if v, ok := args["subject"].(string); ok {
+		vacation.ResponseSubject = v
+	}
+	if v, ok := args["body"].(string); ok {
+		vacation.ResponseBodyPlainText = v
+	}
+	if v, ok := args["html_body"].(string); ok {
+		vacation.ResponseBodyHtml = v
+	}
+	if v, ok := args["contacts_only"].(bool); ok {
+		vacation.RestrictToContacts = v
+	}
+	if v, ok := args["domain_only"].(bool); ok {
+		vacation.RestrictToDomain = v
+	}
+	for _, t := range []struct {
+		name string
+		dst  *int64
+	}{{"start_time", &vacation.StartTime}, {"end_time", &vacation.EndTime}} {
+		v, ok := args[t.name].(string)
+		if !ok {
+			continue
+		}
+		*t.dst = 0
+		if v == "" {
+			continue
+		}
+		at, err := parseSettingTime(v)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("%s: %v", t.name, err)), nil
+		}
+		*t.dst = at.UnixMilli()
+	}
+	if enabled && vacation.ResponseSubject == "" && vacation.ResponseBodyPlainText == "" && vacation.ResponseBodyHtml == "" {
+		return mcp.NewToolResultError("an auto-reply needs a subject or a body"), nil
+	}
+	// Send false and zero values too, or Gmail keeps the old ones.
+	vacation.ForceSendFields = []string{"EnableAutoReply", "RestrictToContacts", "RestrictToDomain",
+		"ResponseSubject", "ResponseBodyPlainText", "ResponseBodyHtml", "StartTime", "EndTime"}
+
+	updated, err := srv.Users.Settings.UpdateVacation("me", vacation).Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("update vacation settings", err), nil
+	}
+	return structuredResult("gmail_set_vacation", format, newVacationResult(updated)), nil
+}
+
+func gmailListSendAsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	resp, err := srv.Users.Settings.SendAs.List("me").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("list send-as addresses", err), nil
+	}
+	out := sendAsListResult{Aliases: []sendAsAlias{}}
+	for _, alias := range resp.SendAs {
+		out.Aliases = append(out.Aliases, sendAsAlias{
+			Email:              alias.SendAsEmail,
+			DisplayName:        alias.DisplayName,
+			ReplyTo:            alias.ReplyToAddress,
+			IsPrimary:          alias.IsPrimary,
+			IsDefault:          alias.IsDefault,
+			VerificationStatus: alias.VerificationStatus,
+			Signature:          services.HTMLToMarkdown(alias.Signature),
+		})
+	}
+	return structuredResult("gmail_list_send_as", format, out), nil
+}
+
+// parseSettingTime accepts RFC 3339 times and dates, which mean midnight UTC.
+func parseSettingTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor YYYY-MM-DD", v)
+	}
+	return t, nil
+}
+
+func newFilterInfo(filter *gmail.Filter, labels []*gmail.Label) filterInfo {
+	out := filterInfo{ID: filter.Id}
+	if c := filter.Criteria; c != nil {
+		out.Criteria = filterCriteria{
+			From:          c.From,
+			To:            c.To,
+			Subject:       c.Subject,
+			Query:         c.Query,
+			NegatedQuery:  c.NegatedQuery,
+			HasAttachment: c.HasAttachment,
+		}
+	}
+	if a := filter.Action; a != nil {
+		out.Action = filterAction{
+			AddLabels:    labelNames(labels, a.AddLabelIds),
+			RemoveLabels: labelNames(labels, a.RemoveLabelIds),
+			Forward:      a.Forward,
+		}
+	}
+	return out
+}
+
+// labelNames maps label IDs to names, keeping IDs it does not know.
+func labelNames(labels []*gmail.Label, ids []string) []string {
+	var names []string
+	for _, id := range ids {
+		name := id
+		for _, label := range labels {
+			if label.Id == id {
+				name = label.Name
+				break
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func newVacationResult(v *gmail.VacationSettings) vacationResult {
+	out := vacationResult{
+		Enabled:      v.EnableAutoReply,
+		Subject:      v.ResponseSubject,
+		Body:         v.ResponseBodyPlainText,
+		HTMLBody:     v.ResponseBodyHtml,
+		ContactsOnly: v.RestrictToContacts,
+		DomainOnly:   v.RestrictToDomain,
+	}
+	if v.StartTime != 0 {
+		out.StartTime = time.UnixMilli(v.StartTime).UTC().Format(time.RFC3339)
+	}
+	if v.EndTime != 0 {
+		out.EndTime = time.UnixMilli(v.EndTime).UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// describe renders the criteria and action of a filter as one line.
+func (f filterInfo) describe() string {
+	var when, then []string
+	for _, c := range []struct{ name, value string }{
+		{"from", f.Criteria.From}, {"to", f.Criteria.To}, {"subject", f.Criteria.Subject},
+		{"matches", f.Criteria.Query}, {"does not match", f.Criteria.NegatedQuery},
+	} {
+		if c.value != "" {
+			when = append(when, fmt.Sprintf("%s %q", c.name, c.value))
+		}
+	}
+	if f.Criteria.HasAttachment {
+		when = append(when, "has attachment")
+	}
+	if len(f.Action.AddLabels) > 0 {
+		then = append(then, "add "+strings.Join(f.Action.AddLabels, ", "))
+	}
+	if len(f.Action.RemoveLabels) > 0 {
+		then = append(then, "remove "+strings.Join(f.Action.RemoveLabels, ", "))
+	}
+	if f.Action.Forward != "" {
+		then = append(then, "forward to "+f.Action.Forward)
+	}
+	return fmt.Sprintf("when %s: %s", strings.Join(when, " and "), strings.Join(then, "; "))
+}
+
+func (r filterListResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Found %d filters:\n\n", len(r.Filters)))
+	for _, f := range r.Filters {
+		result.WriteString(fmt.Sprintf("Filter ID: %s\n%s\n-------------------\n", f.ID, f.describe()))
+	}
+	return result.String()
+}
+
+func (r filterListResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**Found %d filters**\n\n", len(r.Filters)))
+	for _, f := range r.Filters {
+		result.WriteString(fmt.Sprintf("- `%s`: %s\n", f.ID, f.describe()))
+	}
+	return result.String()
+}
+
+func (f filterInfo) Text() string {
+	return fmt.Sprintf("Filter created (Filter ID: %s): %s", f.ID, f.describe())
+}
+
+func (f filterInfo) Markdown() string {
+	return fmt.Sprintf("Filter `%s` created: %s", f.ID, f.describe())
+}
+
+func (r deleteFilterResult) Text() string {
+	return "Filter deleted."
+}
+
+func (r deleteFilterResult) Markdown() string {
+	return fmt.Sprintf("Filter `%s` deleted.", r.ID)
+}
+
+func (v vacationResult) Text() string {
+	if !v.Enabled {
+		return "Vacation auto-reply is off."
+	}
+	var result strings.Builder
+	result.WriteString("Vacation auto-reply is on.\n")
+	if v.StartTime != "" || v.EndTime != "" {
+		result.WriteString(fmt.Sprintf("From %s until %s\n", orDefault(v.StartTime, "now"), orDefault(v.EndTime, "turned off")))
+	}
+	if v.ContactsOnly {
+		result.WriteString("Only replying to contacts.\n")
+	}
+	if v.DomainOnly {
+		result.WriteString("Only replying within the domain.\n")
+	}
+	result.WriteString(fmt.Sprintf("Subject: %s\n\n%s\n", v.Subject, orDefault(v.Body, services.HTMLToMarkdown(v.HTMLBody))))
+	return result.String()
+}
+
+func (v vacationResult) Markdown() string {
+	return v.Text()
+}
+
+func orDefault(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+func (r sendAsListResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Found %d send-as addresses:\n\n", len(r.Aliases)))
+	for _, a := range r.Aliases {
+		result.WriteString(fmt.Sprintf("%s <%s>", a.DisplayName, a.Email))
+		if a.IsDefault {
+			result.WriteString(" (default)")
+		}
+		if a.VerificationStatus != "" && a.VerificationStatus != "accepted" {
+			result.WriteString(" (" + a.VerificationStatus + ")")
+		}
+		result.WriteString("\n")
+		if a.Signature != "" {
+			result.WriteString("Signature:\n" + a.Signature + "\n")
+		}
+		result.WriteString("-------------------\n")
+	}
+	return result.String()
+}
+
+func (r sendAsListResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**Found %d send-as addresses**\n\n", len(r.Aliases)))
+	for _, a := range r.Aliases {
+		result.WriteString(fmt.Sprintf("### %s <%s>", a.DisplayName, a.Email))
+		if a.IsDefault {
+			result.WriteString(" (default)")
+		}
+		result.WriteString("\n\n")
+		if a.Signature != "" {
+			result.WriteString(a.Signature + "\n\n")
+		}
+	}
+	return result.String()
+}
@@ -0,0 +1,301 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"google.golang.org/api/gmail/v1"
+)
+
+// threadSummary describes one conversation in a thread listing.
+type threadSummary struct {
+	ID           string   `json:"id"`
+	Subject      string   `json:"subject"`
+	Participants []string `json:"participants" desc:"Senders in order of first appearance"`
+	MessageCount int      `json:"message_count"`
+	LastDate     string   `json:"last_date" desc:"Date header of the newest message"`
+	Snippet      string   `json:"snippet"`
+}
+
+type threadListResult struct {
+	Threads       []threadSummary `json:"threads"`
+	NextPageToken string          `json:"next_page_token,omitempty" desc:"Pass as page_token to fetch the next page"`
+}
+
+// threadMessage is one message of a conversation with the text it repeats
+// from earlier messages removed.
+type threadMessage struct {
+	ID                string       `json:"id"`
+	Headers           emailHeaders `json:"headers"`
+	Labels            []string     `json:"labels" desc:"Gmail label IDs"`
+	Body              string       `json:"body" desc:"New text of this message"`
+	QuotedTextRemoved bool         `json:"quoted_text_removed" desc:"Whether quoted or repeated text was stripped from body"`
+}
+
+type threadResult struct {
+	ID           string          `json:"id"`
+	Subject      string          `json:"subject"`
+	Participants []string        `json:"participants" desc:"Senders in order of first appearance"`
+	Messages     []threadMessage `json:"messages" desc:"Messages in the order they were sent"`
+}
+
+func gmailListThreadsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	query, _ := request.Params.Arguments["query"].(string)
+	maxResults := mcp.ParseInt64(request, "max_results", defaultSearchResults)
+	if maxResults < 1 || maxResults > maxSearchResults {
+		return mcp.NewToolResultError(fmt.Sprintf("max_results must be between 1 and %d", maxSearchResults)), nil
+	}
+	pageToken, _ := request.Params.Arguments["page_token"].(string)
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	listCall := srv.Users.Threads.List("me").Q(query).MaxResults(maxResults).Context(ctx)
+	if pageToken != "" {
+		listCall.PageToken(pageToken)
+	}
+	resp, err := listCall.Do()
+	if err != nil {
+		return googleErrorResult("list threads", err), nil
+	}
+
+	ids := make([]string, len(resp.Threads))
+	for i, thread := range resp.Threads {
+		ids[i] = thread.Id
+	}
+	threads, err := fetchConcurrently(ctx, ids, func(ctx context.Context, id string) (*gmail.Thread, error) {
+		return srv.Users.Threads.Get("me", id).Format("metadata").MetadataHeaders(searchHeaders...).Context(ctx).Do()
+	})
+	if err != nil {
+		return googleErrorResult("list threads", err), nil
+	}
+
+	out := threadListResult{Threads: []threadSummary{}, NextPageToken: resp.NextPageToken}
+	for _, thread := range threads {
+		if len(thread.Messages) == 0 {
+			continue
+		}
+		first, last := thread.Messages[0], thread.Messages[len(thread.Messages)-1]
+		out.Threads = append(out.Threads, threadSummary{
+			ID:           thread.Id,
+			Subject:      headerValue(first.Payload, "Subject"),
+			Participants: threadParticipants(thread.Messages),
+			MessageCount: len(thread.Messages),
+			LastDate:     headerValue(last.Payload, "Date"),
+			Snippet:      last.Snippet,
+		})
+	}
+	return structuredResult("gmail_list_threads", format, out), nil
+}
+
+func gmailReadThreadHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
+	threadID, ok := request.Params.Arguments["thread_id"].(string)
+	if !ok || threadID == "" {
+		return mcp.NewToolResultError("thread_id must be a non-empty string"), nil
+	}
+	includeQuoted, _ := request.Params.Arguments["include_quoted"].(bool)
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	thread, err := srv.Users.Threads.Get("me", threadID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return googleErrorResult("get thread", err), nil
+	}
+
+	out := threadResult{
+		ID:           thread.Id,
+		Participants: threadParticipants(thread.Messages),
+		Messages:     []threadMessage{},
+	}
+	if len(thread.Messages) > 0 {
+		out.Subject = headerValue(thread.Messages[0].Payload, "Subject")
+	}
+	seen := make(map[string]bool)
+	for _, message := range thread.Messages {
+		body := extractMessageBody(message.Payload)
+		removed := false
+		if !includeQuoted {
+			stripped := dedupeParagraphs(stripQuotedReply(body), seen)
+			removed = stripped != strings.TrimSpace(strings.ReplaceAll(body, "\r\n", "\n"))
+			body = stripped
+		}
+		out.Messages = append(out.Messages, threadMessage{
+			ID:                message.Id,
+			Headers:           newEmailHeaders(message),
+			Labels:            nonNil(message.LabelIds),
+			Body:              body,
+			QuotedTextRemoved: removed,
+		})
+	}
+	return structuredResult("gmail_read_thread", format, out), nil
+}
+
+// threadParticipants returns the distinct sender addresses of messages in
+// order of first appearance.
+func threadParticipants(messages []*gmail.Message) []string {
+	participants := []string{}
+	seen := make(map[string]bool)
+	for _, message := range messages {
+		from := headerValue(message.Payload, "From")
+		key := strings.ToLower(from)
+		if addr, err := mail.ParseAddress(from); err == nil {
+			key = strings.ToLower(addr.Address)
+		}
+		if from == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		participants = append(participants, from)
+	}
+	return participants
+}
+
+var (
+	// attributionLine matches the line mail clients put above a quoted reply,
+	// e.g. "On Mon, 3 Jun 2024 at 10:00, Alice <a@example.com> wrote:".
+	attributionLine = regexp.MustCompile(`(?i)^on\s.+\swrote:\s*$`)
+	// outlookSeparator matches the separators Outlook puts above the original
+	// message.
+	outlookSeparator = regexp.MustCompile(`(?i)^(-{2,}\s*original message\s*-{2,}|_{10,})\s*$`)
+	// outlookHeader matches the lines of the header block Outlook puts above
+	// the original message when it leaves out the separator.
+	outlookHeader = regexp.MustCompile(`(?i)^\*?(from|sent|date|to|cc|subject)\*?:`)
+)
+
+// outlookHeaderBlock reports whether lines start with an Outlook header
+// block: a From line followed by Sent or Date and To or Subject lines. A
+// body that merely starts with "From:" does not.
+func outlookHeaderBlock(lines []string) bool {
+	fields := make(map[string]bool)
+	for i, line := range lines {
+		match := outlookHeader.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil || (i == 0) != strings.EqualFold(match[1], "from") {
+			break
+		}
+		fields[strings.ToLower(match[1])] = true
+	}
+	return (fields["sent"] || fields["date"]) && (fields["to"] || fields["subject"])
+}
+
+// stripQuotedReply removes ">" quoted lines and everything from a reply
+// attribution, Outlook separator or Outlook header block onwards.
+func stripQuotedReply(body string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	var kept []string
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if outlookSeparator.MatchString(trimmed) || attributionLine.MatchString(trimmed) || outlookHeaderBlock(lines[i:]) {
+			break
+		}
+		// Long attributions are often wrapped onto a second line.
+		if i+1 < len(lines) && strings.HasPrefix(strings.ToLower(trimmed), "on ") &&
+			attributionLine.MatchString(trimmed+" "+strings.TrimSpace(lines[i+1])) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// minDedupeLength keeps short paragraphs such as "Thanks," from being
+// treated as repeated text.
+const minDedupeLength = 40
+
+// dedupeParagraphs drops paragraphs of body already recorded in seen, then
+// records the remaining ones.
+func dedupeParagraphs(body string, seen map[string]bool) string {
+	var kept []string
+	for _, paragraph := range strings.Split(body, "\n\n") {
+		key := strings.ToLower(strings.Join(strings.Fields(paragraph), " "))
+		if len(key) >= minDedupeLength {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		kept = append(kept, paragraph)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n\n"))
+}
+
+func (r threadListResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Found %d threads:\n\n", len(r.Threads)))
+	for _, thread := range r.Threads {
+		result.WriteString(fmt.Sprintf("Thread ID: %s\n", thread.ID))
+		result.WriteString(fmt.Sprintf("Subject: %s\n", thread.Subject))
+		result.WriteString(fmt.Sprintf("Participants: %s\n", strings.Join(thread.Participants, ", ")))
+		result.WriteString(fmt.Sprintf("Messages: %d\n", thread.MessageCount))
+		result.WriteString(fmt.Sprintf("Last message: %s\n", thread.LastDate))
+		result.WriteString(fmt.Sprintf("Snippet: %s\n", thread.Snippet))
+		result.WriteString("-------------------\n")
+	}
+	if r.NextPageToken != "" {
+		result.WriteString(fmt.Sprintf("Next page token: %s\n", r.NextPageToken))
+	}
+	return result.String()
+}
+
+func (r threadListResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("**Found %d threads**\n\n", len(r.Threads)))
+	if len(r.Threads) > 0 {
+		result.WriteString("| Subject | Participants | Messages | Last message | Thread ID |\n|---|---|---|---|---|\n")
+		for _, thread := range r.Threads {
+			result.WriteString(fmt.Sprintf("| %s | %s | %d | %s | `%s` |\n",
+				markdownCell(thread.Subject), markdownCell(strings.Join(thread.Participants, ", ")),
+				thread.MessageCount, markdownCell(thread.LastDate), thread.ID))
+		}
+	}
+	if r.NextPageToken != "" {
+		result.WriteString(fmt.Sprintf("\nNext page token: `%s`\n", r.NextPageToken))
+	}
+	return result.String()
+}
+
+func (r threadResult) Text() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Thread: %s\n", r.Subject))
+	result.WriteString(fmt.Sprintf("Participants: %s\n", strings.Join(r.Participants, ", ")))
+	result.WriteString(fmt.Sprintf("Messages: %d\n\n", len(r.Messages)))
+	for i, message := range r.Messages {
+		result.WriteString(fmt.Sprintf("[%d] Message ID: %s\n", i+1, message.ID))
+		result.WriteString(fmt.Sprintf("From: %s\n", message.Headers.From))
+		result.WriteString(fmt.Sprintf("Date: %s\n\n", message.Headers.Date))
+		result.WriteString(message.Body)
+		result.WriteString("\n-------------------\n")
+	}
+	return result.String()
+}
+
+func (r threadResult) Markdown() string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("## %s\n\n", r.Subject))
+	result.WriteString(fmt.Sprintf("Participants: %s\n\n", strings.Join(r.Participants, ", ")))
+	for i, message := range r.Messages {
+		result.WriteString(fmt.Sprintf("### %d. %s, %s\n\n", i+1, message.Headers.From, message.Headers.Date))
+		result.WriteString(message.Body)
+		result.WriteString("\n\n")
+	}
+	return result.String()
+}
@@ -0,0 +1,80 @@
+package tools
+
+import "testing"
+
+func TestStripQuotedReply(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no quote", "Sounds good.\n\nSee you then.", "Sounds good.\n\nSee you then."},
+		{
+			"gmail attribution",
+			"Sounds good.\r\n\r\nOn Mon, 3 Jun 2024 at 10:00, Alice <alice@example.com> wrote:\r\n> Lunch at noon?\r\n",
+			"Sounds good.",
+		},
+		{
+			"wrapped attribution",
+			"Yes.\n\nOn Mon, 3 Jun 2024 at 10:00, Alice Example\n<alice@example.com> wrote:\n> Lunch at noon?",
+			"Yes.",
+		},
+		{
+			"interleaved quoting",
+			"> Lunch at noon?\nYes.\n> And the report?\nAttached.",
+			"Yes.\nAttached.",
+		},
+		{
+			"outlook separator",
+			"Agreed.\n\n-----Original Message-----\nFrom: Bob\nSent: Monday\n\nOld text",
+			"Agreed.",
+		},
+		{
+			"outlook underscore separator",
+			"Agreed.\n\n________________________________\nFrom: Bob <bob@example.com>\nOld text",
+			"Agreed.",
+		},
+		{
+			"outlook header block",
+			"Agreed.\n\nFrom: Bob <bob@example.com>\nSent: Monday, June 3, 2024 10:00 AM\nTo: Alice <alice@example.com>\nSubject: Lunch\n\nOld text",
+			"Agreed.",
+		},
+		{
+			"bold outlook header block",
+			"Agreed.\n\n*From:* Bob\n*Date:* Monday\n*Subject:* Lunch\n\nOld text",
+			"Agreed.",
+		},
+		{
+			"body starting with From:",
+			"From: the facilities team\nThe office is closed on Friday.\nSubject to change.",
+			"From: the facilities team\nThe office is closed on Friday.\nSubject to change.",
+		},
+		{
+			"From: line inside the text",
+			"Notes\nFrom: Paris to Berlin\nTo: be confirmed",
+			"Notes\nFrom: Paris to Berlin\nTo: be confirmed",
+		},
+	}
+	for _, tt := range tests {
+		if got := stripQuotedReply(tt.body); got != tt.want {
+			t.Errorf("%s: stripQuotedReply = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDedupeParagraphs(t *testing.T) {
+	long := "The quarterly report is attached, please review it by Friday."
+	seen := make(map[string]bool)
+	if got := dedupeParagraphs("Hi Bob,\n\n"+long+"\n\nThanks,", seen); got != "Hi Bob,\n\n"+long+"\n\nThanks," {
+		t.Errorf("first message = %q, want it unchanged", got)
+	}
+	// Repeated long paragraphs go, whatever their case and line wrapping;
+	// short ones such as greetings stay.
+	wrapped := "the quarterly report is attached,\nplease   review it by FRIDAY."
+	if got := dedupeParagraphs("Hi Bob,\n\n"+wrapped+"\n\nNew point.\n\nThanks,", seen); got != "Hi Bob,\n\nNew point.\n\nThanks," {
+		t.Errorf("second message = %q", got)
+	}
+	if got := dedupeParagraphs(long, seen); got != "" {
+		t.Errorf("message of only repeated text = %q, want nothing", got)
+	}
+}
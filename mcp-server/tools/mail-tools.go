@@ -5,248 +5,398 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
-	"os"
+	"mime"
 	"strings"
 	"sync"
 
 	"github.com/dongsinhho/ai-repos/mcp-server/services"
 	"github.com/dongsinhho/ai-repos/mcp-server/utils"
 	"github.com/mark3labs/mcp-go/mcp"
-	"github.com/mark3labs/mcp-go/server"
 	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
 )
 
-func RegisterMailTools(s *server.MCPServer) {
+func RegisterMailTools(s ToolAdder) {
 	// Search tool
 	searchTool := mcp.NewTool("gmail_search",
-		mcp.WithDescription("Search emails in Gmail using Gmail's search syntax"),
+		mcp.WithDescription("Search emails in Gmail using Gmail's search syntax. Returns one page of results; "+
+			"when more match, the output ends with a next page token to pass as page_token"),
+		readOnlyAnnotation,
 		mcp.WithString("query", mcp.Required(), mcp.Description("Gmail search query. Follow Gmail's search syntax")),
+		mcp.WithNumber("max_results", mcp.Description(fmt.Sprintf("Maximum number of emails to return (default %d, at most %d)", defaultSearchResults, maxSearchResults))),
+		mcp.WithString("page_token", mcp.Description("next_page_token from a previous search, to fetch the following page")),
+		mcp.WithBoolean("live", mcp.Description("Search Gmail itself even when the local mail cache could answer")),
+		outputFormatParam,
 	)
 	s.AddTool(searchTool, utils.ErrorGuard(gmailSearchHandler))
 
 	// Read email tool
 	readEmailTool := mcp.NewTool("gmail_read_email",
-		mcp.WithDescription("Read a specific email's full content including headers and body"),
+		mcp.WithDescription("Read a specific email's full content including headers and body. The body is the plain text "+
+			"version, or the HTML version converted to markdown when the email has no plain text"),
+		readOnlyAnnotation,
 		mcp.WithString("message_id", mcp.Required(), mcp.Description("ID of the email message to read")),
-		mcp.WithBoolean("include_attachments", mcp.Description("Whether to include attachment information")),
+		mcp.WithBoolean("include_attachments", mcp.Description("Whether to list attachments, including nested ones; fetch them with gmail_get_attachment")),
+		mcp.WithBoolean("include_html", mcp.Description("Also return the original HTML body, when there is one")),
+		outputFormatParam,
 	)
 	s.AddTool(readEmailTool, utils.ErrorGuard(gmailReadEmailHandler))
 
+	// List threads tool
+	listThreadsTool := mcp.NewTool("gmail_list_threads",
+		mcp.WithDescription("List Gmail conversations matching a query, newest first, with their participants and message count"),
+		readOnlyAnnotation,
+		mcp.WithString("query", mcp.Description("Gmail search query (default: all threads)")),
+		mcp.WithNumber("max_results", mcp.Description(fmt.Sprintf("Maximum number of threads to return (default %d, at most %d)", defaultSearchResults, maxSearchResults))),
+		mcp.WithString("page_token", mcp.Description("next_page_token from a previous listing, to fetch the following page")),
+		outputFormatParam,
+	)
+	s.AddTool(listThreadsTool, utils.ErrorGuard(gmailListThreadsHandler))
+
+	// Read thread tool
+	readThreadTool := mcp.NewTool("gmail_read_thread",
+		mcp.WithDescription("Read every message of a conversation in the order sent. Quoted replies and text repeated "+
+			"from earlier messages are stripped so each message shows only what it added"),
+		readOnlyAnnotation,
+		mcp.WithString("thread_id", mcp.Required(), mcp.Description("ID of the thread to read")),
+		mcp.WithBoolean("include_quoted", mcp.Description("Keep quoted and repeated text in message bodies")),
+		outputFormatParam,
+	)
+	s.AddTool(readThreadTool, utils.ErrorGuard(gmailReadThreadHandler))
+
 	// Mark as read tool
 	markReadTool := mcp.NewTool("gmail_mark_read",
 		mcp.WithDescription("Mark a specific email as read (remove UNREAD label)"),
 		mcp.WithString("message_id", mcp.Required(), mcp.Description("ID of the email message to mark as read")),
+		outputFormatParam,
 	)
 	s.AddTool(markReadTool, utils.ErrorGuard(gmailMarkReadHandler))
 
 	// Send email tool
-	sendMailTool := mcp.NewTool("gmail_send_email",
-		mcp.WithDescription("Send an email using Gmail API"),
+	sendMailTool := mcp.NewTool("gmail_send_email", append([]mcp.ToolOption{
+		mcp.WithDescription("Send an email using Gmail API, with optional cc/bcc, HTML body and attachments"),
+		outputFormatParam,
+	}, composeParams...)...)
+	s.AddTool(sendMailTool, utils.ErrorGuard(gmailSendEmailHandler))
+
+	// Reply tool
+	replyTool := mcp.NewTool("gmail_reply",
+		mcp.WithDescription("Reply to an email in its thread, quoting the original message"),
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("ID of the email to reply to")),
+		mcp.WithString("body", mcp.Required(), mcp.Description("Reply text (plain text); the original is quoted below it")),
+		mcp.WithBoolean("reply_all", mcp.Description("Also reply to the original To and Cc recipients")),
+		outputFormatParam,
+	)
+	s.AddTool(replyTool, utils.ErrorGuard(gmailReplyHandler))
+
+	// Forward tool
+	forwardTool := mcp.NewTool("gmail_forward",
+		mcp.WithDescription("Forward an email, including its attachments, to new recipients"),
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("ID of the email to forward")),
 		mcp.WithString("to", mcp.Required(), mcp.Description("Recipient email address(es), comma separated")),
-		mcp.WithString("subject", mcp.Required(), mcp.Description("Email subject")),
-		mcp.WithString("body", mcp.Required(), mcp.Description("Email body (plain text)")),
+		mcp.WithString("body", mcp.Description("Note to put above the forwarded message")),
+		mcp.WithBoolean("include_attachments", mcp.Description("Forward the original attachments (default true)")),
+		outputFormatParam,
 	)
-	s.AddTool(sendMailTool, utils.ErrorGuard(gmailSendEmailHandler))
-}
+	s.AddTool(forwardTool, utils.ErrorGuard(gmailForwardHandler))
 
-var gmailService = sync.OnceValue(func() *gmail.Service {
-	ctx := context.Background()
-	pwd, _ := os.Getwd()
-	log.Println("pwd: ", pwd)
-	credentialsFile := pwd + "/google-credential/credentials.json"
-	tokenFile := pwd + "/google-credential/token.json"
+	registerDraftTools(s)
+	registerAttachmentTools(s)
+	registerLabelTools(s)
+	registerMergeTools(s)
+	registerSettingsTools(s)
+	registerHistoryTools(s)
+	registerCacheTools(s)
 
-	// tokenFile := os.Getenv("GOOGLE_TOKEN_FILE")
-	// if tokenFile == "" {
-	// 	panic("GOOGLE_TOKEN_FILE environment variable must be set")
-	// }
+	// Auth status tool
+	authStatusTool := mcp.NewTool("google_auth_status",
+		mcp.WithDescription("Show which Google account, scopes and token expiry the Gmail tools are using"),
+		readOnlyAnnotation,
+		outputFormatParam,
+	)
+	s.AddTool(authStatusTool, utils.ErrorGuard(googleAuthStatusHandler))
+}
 
-	// credentialsFile := os.Getenv("GOOGLE_CREDENTIALS_FILE")
-	// if credentialsFile == "" {
-	// 	panic("GOOGLE_CREDENTIALS_FILE environment variable must be set")
-	// }
+// Search limits. Results are fetched one page at a time; callers continue
+// with the returned next_page_token.
+const (
+	defaultSearchResults = 20
+	maxSearchResults     = 100
+	// messageFetchWorkers bounds concurrent Messages.Get calls per search.
+	messageFetchWorkers = 8
+)
 
-	client := services.GoogleHttpClient(tokenFile, credentialsFile)
+// searchHeaders are the only headers fetched for search results.
+var searchHeaders = []string{"From", "Subject", "Date"}
 
-	srv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+func gmailSearchHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create Gmail service: %v", err))
+		return googleErrorResult("create Gmail client", err), nil
 	}
 
-	return srv
-})
-
-func gmailSearchHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	query, ok := request.Params.Arguments["query"].(string)
 	if !ok {
 		return mcp.NewToolResultError("query must be a string"), nil
 	}
+	maxResults := mcp.ParseInt64(request, "max_results", defaultSearchResults)
+	if maxResults < 1 || maxResults > maxSearchResults {
+		return mcp.NewToolResultError(fmt.Sprintf("max_results must be between 1 and %d", maxSearchResults)), nil
+	}
+	pageToken, _ := request.Params.Arguments["page_token"].(string)
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
 
-	user := "me"
-	var allMessages []*gmail.Message
-	pageToken := ""
-	for {
-		listCall := gmailService().Users.Messages.List(user).Q(query).MaxResults(100)
-		if pageToken != "" {
-			listCall.PageToken(pageToken)
-		}
-		resp, err := listCall.Do()
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to search emails: %v", err)), nil
-		}
-		if len(resp.Messages) == 0 {
-			break
+	if live, _ := request.Params.Arguments["live"].(bool); !live {
+		if out, ok := searchMailCache(ctx, srv, query, maxResults, pageToken); ok {
+			return structuredResult("gmail_search", format, out), nil
 		}
-		for _, msg := range resp.Messages {
-			message, err := gmailService().Users.Messages.Get(user, msg.Id).Do()
-			if err != nil {
-				log.Printf("Failed to get message %s: %v", msg.Id, err)
-				continue
+	}
+
+	listCall := srv.Users.Messages.List("me").Q(query).MaxResults(maxResults).Context(ctx)
+	if pageToken != "" {
+		listCall.PageToken(pageToken)
+	}
+	resp, err := listCall.Do()
+	if err != nil {
+		return googleErrorResult("search emails", err), nil
+	}
+
+	ids := make([]string, len(resp.Messages))
+	for i, msg := range resp.Messages {
+		ids[i] = msg.Id
+	}
+	messages, err := fetchMessageMetadata(ctx, srv, ids, searchHeaders)
+	if err != nil {
+		return googleErrorResult("search emails", err), nil
+	}
+
+	out := searchResult{Messages: []emailSummary{}, NextPageToken: resp.NextPageToken}
+	for _, message := range messages {
+		out.Messages = append(out.Messages, newEmailSummary(message))
+	}
+	return structuredResult("gmail_search", format, out), nil
+}
+
+// fetchMessageMetadata gets the given headers of each message, in order.
+// Messages that fail to load are logged and skipped.
+func fetchMessageMetadata(ctx context.Context, srv *gmail.Service, ids []string, headers []string) ([]*gmail.Message, error) {
+	return fetchConcurrently(ctx, ids, func(ctx context.Context, id string) (*gmail.Message, error) {
+		return srv.Users.Messages.Get("me", id).Format("metadata").MetadataHeaders(headers...).Context(ctx).Do()
+	})
+}
+
+// fetchConcurrently calls fetch for every ID with at most
+// messageFetchWorkers calls in flight and returns the results in ID order.
+// Failed fetches are logged and skipped; cancelling ctx stops the remaining
+// fetches and returns ctx's error.
+func fetchConcurrently[T any](ctx context.Context, ids []string, fetch func(context.Context, string) (T, error)) ([]T, error) {
+	fetched := make([]T, len(ids))
+	ok := make([]bool, len(ids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(messageFetchWorkers, len(ids)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				item, err := fetch(ctx, ids[i])
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Failed to get %s: %v", ids[i], err)
+					}
+					continue
+				}
+				fetched[i], ok[i] = item, true
 			}
-			allMessages = append(allMessages, message)
-		}
-		if resp.NextPageToken == "" {
-			break
+		}()
+	}
+
+feed:
+	for i := range ids {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
 		}
-		pageToken = resp.NextPageToken
-	}
-
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("Found %d emails:\n\n", len(allMessages)))
-	for _, message := range allMessages {
-		details := make(map[string]string)
-		for _, header := range message.Payload.Headers {
-			switch header.Name {
-			case "From":
-				details["from"] = header.Value
-			case "Subject":
-				details["subject"] = header.Value
-			case "Date":
-				details["date"] = header.Value
-			}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(ids))
+	for i, item := range fetched {
+		if ok[i] {
+			results = append(results, item)
 		}
-		result.WriteString(fmt.Sprintf("Message ID: %s\n", message.Id))
-		result.WriteString(fmt.Sprintf("From: %s\n", details["from"]))
-		result.WriteString(fmt.Sprintf("Subject: %s\n", details["subject"]))
-		result.WriteString(fmt.Sprintf("Date: %s\n", details["date"]))
-		result.WriteString(fmt.Sprintf("Snippet: %s\n", message.Snippet))
-		result.WriteString("-------------------\n")
-	}
-	return mcp.NewToolResultText(result.String()), nil
+	}
+	return results, nil
 }
 
 func gmailReadEmailHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
 	messageID, ok := request.Params.Arguments["message_id"].(string)
 	if !ok {
 		return mcp.NewToolResultError("message_id must be a string"), nil
 	}
 
 	includeAttachments, _ := request.Params.Arguments["include_attachments"].(bool)
+	includeHTML, _ := request.Params.Arguments["include_html"].(bool)
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
 
 	// Get the full email message
-	message, err := gmailService().Users.Messages.Get("me", messageID).Format("full").Do()
+	message, err := srv.Users.Messages.Get("me", messageID).Format("full").Context(ctx).Do()
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("failed to get email: %v", err)), nil
+		return googleErrorResult("get email", err), nil
 	}
 
-	var result strings.Builder
-
-	// Extract headers
-	headers := make(map[string]string)
-	for _, header := range message.Payload.Headers {
-		switch header.Name {
-		case "From", "To", "Cc", "Subject", "Date":
-			headers[header.Name] = header.Value
-			result.WriteString(fmt.Sprintf("%s: %s\n", header.Name, header.Value))
-		}
+	out := emailMessage{
+		ID:       message.Id,
+		ThreadID: message.ThreadId,
+		Headers:  newEmailHeaders(message),
+		Labels:   nonNil(message.LabelIds),
 	}
-	result.WriteString("\n")
-
-	// Extract body
-	body := extractMessageBody(message.Payload)
-	result.WriteString("Body:\n")
-	result.WriteString(body)
-	result.WriteString("\n")
-
-	// Handle attachments if requested
-	if includeAttachments && len(message.Payload.Parts) > 0 {
-		result.WriteString("\nAttachments:\n")
-		for _, part := range message.Payload.Parts {
-			if part.Filename != "" {
-				result.WriteString(fmt.Sprintf("- %s (Size: %d bytes)\n",
-					part.Filename, part.Body.Size))
-			}
+	out.Body, out.BodyFormat = messageBody(message.Payload)
+	if includeHTML {
+		_, out.HTMLBody = messageBodies(message.Payload)
+	}
+	if includeAttachments {
+		out.Attachments = []attachmentInfo{}
+		for _, part := range attachmentParts(message.Payload) {
+			out.Attachments = append(out.Attachments, newAttachmentInfo(part))
 		}
 	}
-
-	return mcp.NewToolResultText(result.String()), nil
+	return structuredResult("gmail_read_email", format, out), nil
 }
 
 func gmailMarkReadHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
+	}
+
 	messageID, ok := request.Params.Arguments["message_id"].(string)
 	if !ok {
 		return mcp.NewToolResultError("message_id must be a string"), nil
 	}
 
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
 	user := "me"
 	modifyReq := &gmail.ModifyMessageRequest{
 		RemoveLabelIds: []string{"UNREAD"},
 	}
-	_, err := gmailService().Users.Messages.Modify(user, messageID, modifyReq).Do()
+	message, err := srv.Users.Messages.Modify(user, messageID, modifyReq).Context(ctx).Do()
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("failed to mark email as read: %v", err)), nil
+		return googleErrorResult("mark email as read", err), nil
 	}
-	return mcp.NewToolResultText("Email marked as read."), nil
+	return structuredResult("gmail_mark_read", format, markReadResult{ID: message.Id, Labels: nonNil(message.LabelIds)}), nil
 }
 
 func gmailSendEmailHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	to, ok := request.Params.Arguments["to"].(string)
-	if !ok || to == "" {
-		return mcp.NewToolResultError("to must be a non-empty string"), nil
+	srv, err := gmailServiceFor(ctx)
+	if err != nil {
+		return googleErrorResult("create Gmail client", err), nil
 	}
-	subject, ok := request.Params.Arguments["subject"].(string)
-	if !ok {
-		return mcp.NewToolResultError("subject must be a string"), nil
+
+	email, err := composeEmail(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
 	}
-	body, ok := request.Params.Arguments["body"].(string)
-	if !ok {
-		return mcp.NewToolResultError("body must be a string"), nil
+	format, err := outputFormat(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
 	}
 
-	// Encode subject as UTF-8 base64 for proper header formatting
-	subjectHeader := fmt.Sprintf("=?UTF-8?B?%s?=", base64.StdEncoding.EncodeToString([]byte(subject)))
-	msgStr := fmt.Sprintf("To: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=\"UTF-8\"\r\n\r\n%s", to, subjectHeader, body)
-	msg := &gmail.Message{
-		Raw: base64.URLEncoding.EncodeToString([]byte(msgStr)),
-	}
-	_, err := gmailService().Users.Messages.Send("me", msg).Do()
+	sent, err := sendEmail(ctx, srv, email, "")
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("failed to send email: %v", err)), nil
+		return googleErrorResult("send email", err), nil
 	}
-	return mcp.NewToolResultText("Email sent successfully."), nil
+	return structuredResult("gmail_send_email", format, sent), nil
 }
 
+// Formats of emailMessage.Body.
+const (
+	bodyFormatText     = "text"
+	bodyFormatMarkdown = "markdown"
+)
+
+// extractMessageBody returns the plain text body of payload, or its HTML
+// body converted to markdown when there is no plain text part.
 func extractMessageBody(payload *gmail.MessagePart) string {
-	if payload.MimeType == "text/plain" && payload.Body.Data != "" {
-		data, err := base64.URLEncoding.DecodeString(payload.Body.Data)
-		if err != nil {
-			return fmt.Sprintf("Error decoding body: %v", err)
-		}
-		return string(data)
+	body, _ := messageBody(payload)
+	return body
+}
+
+// messageBody is extractMessageBody that also reports whether the body is
+// text or markdown converted from HTML.
+func messageBody(payload *gmail.MessagePart) (body, format string) {
+	text, html := messageBodies(payload)
+	switch {
+	case strings.TrimSpace(text) != "":
+		return text, bodyFormatText
+	case html != "":
+		return services.HTMLToMarkdown(html), bodyFormatMarkdown
 	}
+	return "No readable text body found", bodyFormatText
+}
 
-	if payload.Parts != nil {
-		for _, part := range payload.Parts {
-			if part.MimeType == "text/plain" {
-				data, err := base64.URLEncoding.DecodeString(part.Body.Data)
-				if err != nil {
-					continue
-				}
-				return string(data)
+// messageBodies walks the MIME tree of payload depth first and returns the
+// first plain text and HTML bodies, converted to UTF-8 from their charset.
+// Attachments, including attached messages, are skipped. The Gmail API has
+// already undone the transfer encoding (base64, quoted-printable).
+func messageBodies(payload *gmail.MessagePart) (text, html string) {
+	var walk func(part *gmail.MessagePart)
+	walk = func(part *gmail.MessagePart) {
+		// Parts with a file name are attachments, and message/rfc822 parts
+		// attached messages with bodies of their own.
+		if part == nil || part.Filename != "" || strings.EqualFold(part.MimeType, "message/rfc822") {
+			return
+		}
+		switch part.MimeType {
+		case "text/plain", "text/html":
+			if part.Body == nil || part.Body.Data == "" {
+				break
 			}
+			data, err := decodeBodyData(part.Body.Data)
+			if err != nil {
+				log.Printf("failed to decode %s part %s: %v", part.MimeType, part.PartId, err)
+				break
+			}
+			_, params, _ := mime.ParseMediaType(headerValue(part, "Content-Type"))
+			decoded := services.DecodeCharset(data, params["charset"])
+			if part.MimeType == "text/plain" && text == "" {
+				text = decoded
+			} else if part.MimeType == "text/html" && html == "" {
+				html = decoded
+			}
+		}
+		for _, child := range part.Parts {
+			walk(child)
 		}
 	}
+	walk(payload)
+	return text, html
+}
 
-	return "No readable text body found"
+// decodeBodyData decodes a Gmail body, which is base64url with or without
+// padding.
+func decodeBodyData(data string) ([]byte, error) {
+	if decoded, err := base64.URLEncoding.DecodeString(data); err == nil {
+		return decoded, nil
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
 }
@@ -0,0 +1,207 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Output formats accepted by the output_format argument.
+const (
+	OutputText     = "text"
+	OutputJSON     = "json"
+	OutputMarkdown = "markdown"
+)
+
+// outputFormatParam adds the output_format argument to a tool.
+var outputFormatParam = mcp.WithString("output_format",
+	mcp.Enum(OutputText, OutputJSON, OutputMarkdown),
+	mcp.Description("Format of the readable result (default text). The JSON result is attached to small responses; "+
+		"ask for json to get it for large ones"))
+
+// maxEmbeddedResult bounds the JSON result attached to text and markdown
+// responses, so large results such as message bodies and attachment
+// content are not sent twice.
+const maxEmbeddedResult = 16 << 10
+
+// toolOutputs maps each tool with structured output to the type of its
+// result. The registry publishes the JSON schema of that type as a resource.
+var toolOutputs = map[string]any{
+	"gmail_search":             searchResult{},
+	"gmail_read_email":         emailMessage{},
+	"gmail_list_threads":       threadListResult{},
+	"gmail_read_thread":        threadResult{},
+	"gmail_changes_since":      changesResult{},
+	"gmail_cache_stats":        cacheStats{},
+	"gmail_cache_purge":        cachePurgeResult{},
+	"gmail_mark_read":          markReadResult{},
+	"gmail_send_email":         sendResult{},
+	"gmail_reply":              sendResult{},
+	"gmail_forward":            sendResult{},
+	"gmail_create_draft":       draftResult{},
+	"gmail_update_draft":       draftResult{},
+	"gmail_list_drafts":        draftListResult{},
+	"gmail_send_draft":         sendResult{},
+	"gmail_delete_draft":       deleteDraftResult{},
+	"gmail_get_attachment":     attachmentContent{},
+	"gmail_save_attachment":    savedAttachment{},
+	"gmail_list_labels":        labelListResult{},
+	"gmail_create_label":       labelInfo{},
+	"gmail_modify_labels":      modifyLabelsResult{},
+	"gmail_archive":            modifyLabelsResult{},
+	"gmail_trash":              modifyLabelsResult{},
+	"gmail_untrash":            modifyLabelsResult{},
+	"gmail_star":               modifyLabelsResult{},
+	"gmail_mark_unread":        modifyLabelsResult{},
+	"gmail_mark_spam":          modifyLabelsResult{},
+	"gmail_mail_merge_preview": mergeResult{},
+	"gmail_mail_merge":         mergeResult{},
+	"gmail_list_filters":       filterListResult{},
+	"gmail_create_filter":      filterInfo{},
+	"gmail_delete_filter":      deleteFilterResult{},
+	"gmail_get_vacation":       vacationResult{},
+	"gmail_set_vacation":       vacationResult{},
+	"gmail_list_send_as":       sendAsListResult{},
+	"google_auth_status":       authStatus{},
+}
+
+// outputSchemaURI is the resource holding the output schema of tool.
+func outputSchemaURI(tool string) string {
+	return "schema://tools/" + tool + "/output"
+}
+
+// structuredOutput is a tool result that can render itself for people.
+type structuredOutput interface {
+	Text() string
+	Markdown() string
+}
+
+// outputFormat reads the output_format argument.
+func outputFormat(request mcp.CallToolRequest) (string, error) {
+	format, _ := request.Params.Arguments["output_format"].(string)
+	switch format {
+	case "":
+		return OutputText, nil
+	case OutputText, OutputJSON, OutputMarkdown:
+		return format, nil
+	default:
+		return "", fmt.Errorf("output_format must be one of %s, %s or %s", OutputText, OutputJSON, OutputMarkdown)
+	}
+}
+
+// structuredResult renders out in format. Unless format is JSON, the JSON
+// form is attached as an embedded application/json resource so agents do
+// not have to parse the text, as long as it is small; otherwise _meta
+// says it was left out.
+func structuredResult(tool, format string, out structuredOutput) *mcp.CallToolResult {
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to encode result: %v", err))
+	}
+
+	var result *mcp.CallToolResult
+	switch format {
+	case OutputJSON:
+		result = mcp.NewToolResultText(string(b))
+	case OutputMarkdown:
+		result = mcp.NewToolResultText(out.Markdown())
+	default:
+		result = mcp.NewToolResultText(out.Text())
+	}
+	result.Meta = map[string]interface{}{"outputSchema": outputSchemaURI(tool)}
+	if format != OutputJSON && len(b) > maxEmbeddedResult {
+		result.Meta["structuredResult"] = "omitted from large responses; ask for output_format json"
+	} else if format != OutputJSON {
+		result.Content = append(result.Content, mcp.NewEmbeddedResource(mcp.TextResourceContents{
+			URI:      "result://tools/" + tool,
+			MIMEType: "application/json",
+			Text:     string(b),
+		}))
+	}
+	return result
+}
+
+// outputSchemaResource returns the resource and handler that publish the
+// output schema of tool.
+func outputSchemaResource(tool string, out any) (mcp.Resource, server.ResourceHandlerFunc) {
+	uri := outputSchemaURI(tool)
+	resource := mcp.NewResource(uri, tool+" output schema",
+		mcp.WithResourceDescription("JSON schema of the structured result of "+tool),
+		mcp.WithMIMEType("application/schema+json"),
+	)
+	handler := func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		schema := jsonSchema(reflect.TypeOf(out))
+		schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+		schema["title"] = tool
+		b, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{
+			URI:      uri,
+			MIMEType: "application/schema+json",
+			Text:     string(b),
+		}}, nil
+	}
+	return resource, handler
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema describes how encoding/json marshals t. Fields are required
+// unless tagged omitempty; a "desc" tag becomes the description.
+func jsonSchema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := jsonSchema(field.Type)
+			if desc := field.Tag.Get("desc"); desc != "" {
+				property["description"] = desc
+			}
+			properties[name] = property
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]any{}
+}
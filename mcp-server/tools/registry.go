@@ -0,0 +1,156 @@
+package tools
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Tool groups, usable in ENABLE_TOOLS / DISABLE_TOOLS in place of tool names.
+const (
+	GroupMail       = "mail"
+	GroupFilesystem = "filesystem"
+	GroupWeb        = "web"
+	GroupSearch     = "search"
+	GroupAWS        = "aws"
+	GroupSession    = "session"
+)
+
+// ProfileReadOnly drops every tool not annotated as read-only.
+const ProfileReadOnly = "readonly"
+
+// readOnlyAnnotation marks a tool as not modifying its environment so it
+// survives the read-only profile.
+var readOnlyAnnotation = mcp.WithToolAnnotation(mcp.ToolAnnotation{
+	ReadOnlyHint:  true,
+	OpenWorldHint: true,
+})
+
+// ToolAdder is the part of *server.MCPServer the Register* functions use.
+type ToolAdder interface {
+	AddTool(tool mcp.Tool, handler server.ToolHandlerFunc)
+}
+
+// Filter decides which tools are advertised and which need confirmation.
+// Entries are either a group name or a glob matched against the tool name
+// (e.g. "gmail_*"). An empty Allow list allows everything; Deny always wins.
+type Filter struct {
+	Allow    []string
+	Deny     []string
+	ReadOnly bool
+	// Confirm lists the tools that only run after confirm_action.
+	Confirm []string
+}
+
+// FilterFromEnv builds a Filter from ENABLE_TOOLS, DISABLE_TOOLS,
+// CONFIRM_TOOLS (comma separated) and TOOL_PROFILE. CONFIRM_TOOLS defaults
+// to the tools that send or delete mail; "none" turns confirmation off.
+func FilterFromEnv() Filter {
+	confirm := defaultConfirmTools
+	if v, ok := os.LookupEnv("CONFIRM_TOOLS"); ok {
+		confirm = splitList(v)
+		if len(confirm) == 1 && strings.EqualFold(confirm[0], "none") {
+			confirm = nil
+		}
+	}
+	return Filter{
+		Allow:    splitList(os.Getenv("ENABLE_TOOLS")),
+		Deny:     splitList(os.Getenv("DISABLE_TOOLS")),
+		ReadOnly: strings.EqualFold(os.Getenv("TOOL_PROFILE"), ProfileReadOnly),
+		Confirm:  confirm,
+	}
+}
+
+// NeedsConfirmation reports whether tool, registered under group, only
+// runs after confirm_action. Read-only tools never do.
+func (f Filter) NeedsConfirmation(group string, tool mcp.Tool) bool {
+	return !tool.Annotations.ReadOnlyHint && matchAny(f.Confirm, group, tool.Name)
+}
+
+// Allowed reports whether tool, registered under group, passes the filter.
+func (f Filter) Allowed(group string, tool mcp.Tool) bool {
+	if f.ReadOnly && !tool.Annotations.ReadOnlyHint {
+		return false
+	}
+	if matchAny(f.Deny, group, tool.Name) {
+		return false
+	}
+	return len(f.Allow) == 0 || matchAny(f.Allow, group, tool.Name)
+}
+
+func matchAny(patterns []string, group, name string) bool {
+	for _, p := range patterns {
+		if p == group {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Registry adds tools to an MCP server, skipping those rejected by its Filter.
+type Registry struct {
+	server   *server.MCPServer
+	filter   Filter
+	profiles []string
+}
+
+func NewRegistry(s *server.MCPServer, filter Filter) *Registry {
+	return &Registry{server: s, filter: filter}
+}
+
+// ScopeProfiles returns the Google scope profiles needed by the registered tools.
+func (r *Registry) ScopeProfiles() []string {
+	return r.profiles
+}
+
+// Group returns a ToolAdder that registers tools under the given group.
+func (r *Registry) Group(name string) ToolAdder {
+	return &groupAdder{registry: r, group: name}
+}
+
+type groupAdder struct {
+	registry *Registry
+	group    string
+}
+
+func (g *groupAdder) AddTool(tool mcp.Tool, handler server.ToolHandlerFunc) {
+	if !g.registry.filter.Allowed(g.group, tool) {
+		return
+	}
+	if g.registry.filter.NeedsConfirmation(g.group, tool) {
+		tool.Description += ". Needs confirmation: the call returns a preview and a token for confirm_action"
+		// The gate runs outside the tool's own ErrorGuard.
+		handler = utils.ErrorGuard(requireConfirmation(tool.Name, handler))
+	}
+	if profiles, ok := toolScopeProfiles[tool.Name]; ok {
+		for _, profile := range profiles {
+			if !slices.Contains(g.registry.profiles, profile) {
+				g.registry.profiles = append(g.registry.profiles, profile)
+			}
+		}
+		handler = requireScopes(tool.Name, profiles, handler)
+	}
+	if out, ok := toolOutputs[tool.Name]; ok {
+		tool.Description += ". The JSON result follows the schema at " + outputSchemaURI(tool.Name)
+		g.registry.server.AddResource(outputSchemaResource(tool.Name, out))
+	}
+	g.registry.server.AddTool(tool, handler)
+}
@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestFilterAllowed(t *testing.T) {
+	send := mcp.NewTool("gmail_send")
+	search := mcp.NewTool("gmail_search", readOnlyAnnotation)
+	readFile := mcp.NewTool("read_file", readOnlyAnnotation)
+	tests := []struct {
+		name   string
+		filter Filter
+		group  string
+		tool   mcp.Tool
+		want   bool
+	}{
+		{"no filter", Filter{}, GroupMail, send, true},
+		{"allowed group", Filter{Allow: []string{GroupMail}}, GroupMail, send, true},
+		{"other group", Filter{Allow: []string{GroupMail}}, GroupFilesystem, readFile, false},
+		{"allowed glob", Filter{Allow: []string{"gmail_*"}}, GroupMail, search, true},
+		{"glob miss", Filter{Allow: []string{"gmail_s?nd"}}, GroupMail, search, false},
+		{"allowed name", Filter{Allow: []string{"read_file"}}, GroupFilesystem, readFile, true},
+		{"denied group", Filter{Deny: []string{GroupMail}}, GroupMail, search, false},
+		{"denied glob", Filter{Deny: []string{"*_send"}}, GroupMail, send, false},
+		{"deny wins over allow", Filter{Allow: []string{GroupMail}, Deny: []string{"gmail_send"}}, GroupMail, send, false},
+		{"deny leaves others", Filter{Allow: []string{GroupMail}, Deny: []string{"gmail_send"}}, GroupMail, search, true},
+		{"read-only drops writes", Filter{ReadOnly: true}, GroupMail, send, false},
+		{"read-only keeps reads", Filter{ReadOnly: true}, GroupMail, search, true},
+		{"read-only wins over allow", Filter{ReadOnly: true, Allow: []string{"gmail_send"}}, GroupMail, send, false},
+		{"bad glob matches nothing", Filter{Allow: []string{"gmail_["}}, GroupMail, send, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Allowed(tt.group, tt.tool); got != tt.want {
+			t.Errorf("%s: Allowed(%s, %s) = %v, want %v", tt.name, tt.group, tt.tool.Name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterNeedsConfirmation(t *testing.T) {
+	filter := Filter{Confirm: []string{"gmail_send", "gmail_search", GroupFilesystem}}
+	tests := []struct {
+		group string
+		tool  mcp.Tool
+		want  bool
+	}{
+		{GroupMail, mcp.NewTool("gmail_send"), true},
+		{GroupMail, mcp.NewTool("gmail_trash"), false},
+		{GroupMail, mcp.NewTool("gmail_search", readOnlyAnnotation), false},
+		{GroupFilesystem, mcp.NewTool("write_file"), true},
+	}
+	for _, tt := range tests {
+		if got := filter.NeedsConfirmation(tt.group, tt.tool); got != tt.want {
+			t.Errorf("NeedsConfirmation(%s, %s) = %v, want %v", tt.group, tt.tool.Name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterFromEnv(t *testing.T) {
+	t.Setenv("ENABLE_TOOLS", " mail, read_* ,")
+	t.Setenv("DISABLE_TOOLS", "gmail_send")
+	t.Setenv("TOOL_PROFILE", "ReadOnly")
+	t.Setenv("CONFIRM_TOOLS", "none")
+	f := FilterFromEnv()
+	if len(f.Allow) != 2 || f.Allow[0] != "mail" || f.Allow[1] != "read_*" || len(f.Deny) != 1 || !f.ReadOnly || f.Confirm != nil {
+		t.Errorf("FilterFromEnv = %+v", f)
+	}
+}
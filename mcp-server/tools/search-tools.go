@@ -1,9 +1,5 @@
 package tools
 
-import (
-	"github.com/mark3labs/mcp-go/server"
-)
-
-func RegisterSearchTools(s *server.MCPServer) {
+func RegisterSearchTools(s ToolAdder) {
 
 }
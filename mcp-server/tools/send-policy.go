@@ -0,0 +1,401 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/api/gmail/v1"
+)
+
+// errSendPolicy wraps every refusal of the send policy, and errSendQuota
+// those caused by a used up quota.
+var (
+	errSendPolicy = errors.New("blocked by send policy")
+	errSendQuota  = errors.New("quota reached")
+)
+
+// sendPolicy restricts outgoing mail. Address lists hold full addresses or
+// "@domain" entries; zero limits mean no limit.
+type sendPolicy struct {
+	// Allowed, when not empty, lists the only permitted recipients.
+	Allowed []string
+	// Blocked recipients are refused even when Allowed matches them.
+	Blocked       []string
+	MaxRecipients int
+	SessionQuota  int
+	DailyQuota    int
+	// DryRun returns the composed message instead of sending it.
+	DryRun bool
+}
+
+// sendPolicyFromEnv reads SEND_ALLOW, SEND_DENY, SEND_MAX_RECIPIENTS,
+// SEND_SESSION_QUOTA, SEND_DAILY_QUOTA and SEND_DRY_RUN.
+func sendPolicyFromEnv() (sendPolicy, error) {
+	p := sendPolicy{
+		Allowed: lowerList(os.Getenv("SEND_ALLOW")),
+		Blocked: lowerList(os.Getenv("SEND_DENY")),
+	}
+	for _, limit := range []struct {
+		name string
+		dst  *int
+	}{
+		{"SEND_MAX_RECIPIENTS", &p.MaxRecipients},
+		{"SEND_SESSION_QUOTA", &p.SessionQuota},
+		{"SEND_DAILY_QUOTA", &p.DailyQuota},
+	} {
+		v := os.Getenv(limit.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return sendPolicy{}, fmt.Errorf("%s must be a non-negative integer, got %q", limit.name, v)
+		}
+		*limit.dst = n
+	}
+	if v := os.Getenv("SEND_DRY_RUN"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return sendPolicy{}, fmt.Errorf("SEND_DRY_RUN must be true or false, got %q", v)
+		}
+		p.DryRun = dryRun
+	}
+	return p, nil
+}
+
+func lowerList(s string) []string {
+	list := splitList(s)
+	for i := range list {
+		list[i] = strings.ToLower(list[i])
+	}
+	return list
+}
+
+// checkRecipients rejects messages with too many or disallowed recipients.
+func (p sendPolicy) checkRecipients(recipients []*mail.Address) error {
+	if p.MaxRecipients > 0 && len(recipients) > p.MaxRecipients {
+		return fmt.Errorf("%w: %d recipients, at most %d allowed (SEND_MAX_RECIPIENTS)",
+			errSendPolicy, len(recipients), p.MaxRecipients)
+	}
+	for _, addr := range recipients {
+		switch {
+		case addressMatches(p.Blocked, addr.Address):
+			return fmt.Errorf("%w: %s is blocked by SEND_DENY", errSendPolicy, addr.Address)
+		case len(p.Allowed) > 0 && !addressMatches(p.Allowed, addr.Address):
+			return fmt.Errorf("%w: %s is not allowed by SEND_ALLOW", errSendPolicy, addr.Address)
+		}
+	}
+	return nil
+}
+
+// addressMatches reports whether address equals an entry or is in the
+// domain of an "@domain" entry. Entries must be lower case.
+func addressMatches(entries []string, address string) bool {
+	address = strings.ToLower(address)
+	_, domain, _ := strings.Cut(address, "@")
+	for _, entry := range entries {
+		if entry == address || (domain != "" && entry == "@"+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send counters. Daily counts are per user and UTC day, session counts per
+// MCP client session; both live in memory and reset on restart.
+var (
+	quotaMu      sync.Mutex
+	sessionSends = make(map[string]int)
+	dailySends   = make(map[string]dailyCount)
+)
+
+type dailyCount struct {
+	day string
+	n   int
+}
+
+// reserveSend counts one message against the caller's quotas. The returned
+// func gives the reservation back when the send fails.
+func (p sendPolicy) reserveSend(ctx context.Context) (release func(), err error) {
+	user := session.UserID(ctx)
+	sessionKey := clientSessionKey(ctx)
+	day := time.Now().UTC().Format(time.DateOnly)
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if err := p.quotaLeft(user, sessionKey, day); err != nil {
+		return nil, err
+	}
+	sessionSends[sessionKey]++
+	daily := dailySends[user]
+	daily.day = day
+	daily.n++
+	dailySends[user] = daily
+
+	return func() {
+		quotaMu.Lock()
+		defer quotaMu.Unlock()
+		sessionSends[sessionKey]--
+		if d := dailySends[user]; d.day == day {
+			d.n--
+			dailySends[user] = d
+		}
+	}, nil
+}
+
+// checkQuota is reserveSend without counting anything.
+func (p sendPolicy) checkQuota(ctx context.Context) error {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	return p.quotaLeft(session.UserID(ctx), clientSessionKey(ctx), time.Now().UTC().Format(time.DateOnly))
+}
+
+// quotaLeft fails when the quotas allow no more messages today. It drops
+// the counts of earlier days, and must be called with quotaMu held.
+func (p sendPolicy) quotaLeft(user, sessionKey, day string) error {
+	for u, d := range dailySends {
+		if d.day != day {
+			delete(dailySends, u)
+		}
+	}
+	if p.SessionQuota > 0 && sessionSends[sessionKey] >= p.SessionQuota {
+		return fmt.Errorf("%w: %w: %d emails per session (SEND_SESSION_QUOTA)", errSendPolicy, errSendQuota, p.SessionQuota)
+	}
+	if p.DailyQuota > 0 && dailySends[user].n >= p.DailyQuota {
+		return fmt.Errorf("%w: %w: %d emails per day (SEND_DAILY_QUOTA)", errSendPolicy, errSendQuota, p.DailyQuota)
+	}
+	return nil
+}
+
+// clientSessionKey identifies the MCP client session of ctx, falling back to
+// the user for transports without sessions.
+func clientSessionKey(ctx context.Context) string {
+	if cs := server.ClientSessionFromContext(ctx); cs != nil {
+		return cs.SessionID()
+	}
+	return session.UserID(ctx)
+}
+
+// ForgetSendQuota drops the session counter of a client session once it
+// ends.
+func ForgetSendQuota(ctx context.Context, cs server.ClientSession) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	delete(sessionSends, cs.SessionID())
+}
+
+// sendUnderPolicy sends the message raw addressed to recipients by calling
+// send, unless the send policy refuses it. In dry-run mode raw is returned
+// instead and nothing is sent or counted.
+func sendUnderPolicy(ctx context.Context, recipients []*mail.Address, raw []byte, send func() (*gmail.Message, error)) (sendResult, error) {
+	policy, err := sendPolicyFromEnv()
+	if err != nil {
+		return sendResult{}, err
+	}
+	if err := policy.checkRecipients(recipients); err != nil {
+		return sendResult{}, err
+	}
+	if policy.DryRun {
+		return sendResult{DryRun: true, MIME: string(raw)}, nil
+	}
+
+	release, err := policy.reserveSend(ctx)
+	if err != nil {
+		return sendResult{}, err
+	}
+	sent, err := send()
+	if err != nil {
+		release()
+		return sendResult{}, err
+	}
+	return sendResult{ID: sent.Id, ThreadID: sent.ThreadId}, nil
+}
+
+// outgoingMessages resolve, for tools that send mail, the headers of each
+// message a call would send, so that requireConfirmation can apply the send
+// policy and show the final recipients and subject before asking instead of
+// after the user approved. Bodies are left out.
+var outgoingMessages = map[string]func(ctx context.Context, request mcp.CallToolRequest) ([]*services.Email, error){
+	"gmail_send_email": func(ctx context.Context, request mcp.CallToolRequest) ([]*services.Email, error) {
+		email, err := composeEmail(request)
+		if err != nil {
+			return nil, err
+		}
+		return []*services.Email{{From: email.From, To: email.To, Cc: email.Cc, Bcc: email.Bcc, Subject: email.Subject}}, nil
+	},
+	"gmail_reply": func(ctx context.Context, request mcp.CallToolRequest) ([]*services.Email, error) {
+		srv, err := gmailServiceFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		messageID, _ := request.Params.Arguments["message_id"].(string)
+		replyAll, _ := request.Params.Arguments["reply_all"].(bool)
+		original, err := srv.Users.Messages.Get("me", messageID).Format("metadata").
+			MetadataHeaders("From", "Reply-To", "To", "Cc", "Subject").Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		profile, err := srv.Users.GetProfile("me").Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		to, cc := replyRecipients(original.Payload, profile.EmailAddress, replyAll)
+		return []*services.Email{{
+			To:      to,
+			Cc:      cc,
+			Subject: prefixSubject(headerValue(original.Payload, "Subject"), "Re: ", replyPrefix),
+		}}, nil
+	},
+	"gmail_forward": func(ctx context.Context, request mcp.CallToolRequest) ([]*services.Email, error) {
+		srv, err := gmailServiceFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		messageID, _ := request.Params.Arguments["message_id"].(string)
+		to, _ := request.Params.Arguments["to"].(string)
+		recipients, err := services.ParseAddressList(to)
+		if err != nil {
+			return nil, err
+		}
+		original, err := srv.Users.Messages.Get("me", messageID).Format("metadata").
+			MetadataHeaders("Subject").Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return []*services.Email{{
+			To:      addressStrings(recipients),
+			Subject: prefixSubject(headerValue(original.Payload, "Subject"), "Fwd: ", forwardPrefix),
+		}}, nil
+	},
+	"gmail_send_draft": func(ctx context.Context, request mcp.CallToolRequest) ([]*services.Email, error) {
+		srv, err := gmailServiceFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		draftID, _ := request.Params.Arguments["draft_id"].(string)
+		draft, err := srv.Users.Drafts.Get("me", draftID).Format("raw").Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := decodeBodyData(draft.Message.Raw)
+		if err != nil {
+			return nil, err
+		}
+		headers, err := draftHeaders(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []*services.Email{headers}, nil
+	},
+	"gmail_create_filter": func(ctx context.Context, request mcp.CallToolRequest) ([]*services.Email, error) {
+		to, _ := request.Params.Arguments["forward_to"].(string)
+		if to == "" {
+			return nil, nil
+		}
+		recipients, err := services.ParseAddressList(to)
+		if err != nil {
+			return nil, err
+		}
+		return []*services.Email{{To: addressStrings(recipients)}}, nil
+	},
+	"gmail_mail_merge": func(ctx context.Context, request mcp.CallToolRequest) ([]*services.Email, error) {
+		if mode, _ := request.Params.Arguments["mode"].(string); mode != mergeModeSend {
+			return nil, nil
+		}
+		m, err := parseMailMerge(request)
+		if err != nil {
+			return nil, err
+		}
+		srv, err := gmailServiceFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		account, err := mailboxFor(ctx, srv)
+		if err != nil {
+			return nil, err
+		}
+		job, err := openMergeJob(account, m.id, mergeModeSend)
+		if err != nil {
+			return nil, err
+		}
+		// Rows that fail to render fail on their own when the merge runs.
+		var messages []*services.Email
+		for i := range m.rows {
+			row, email, err := m.render(i)
+			if err != nil {
+				continue
+			}
+			if done, _ := job.claim(mergeRecipient(row.To), false); done != "" {
+				continue
+			}
+			messages = append(messages, &services.Email{To: email.To, Subject: email.Subject})
+		}
+		return messages, nil
+	},
+}
+
+// resolveOutgoing returns the messages a call of tool would send, or nil
+// when tool sends nothing or they cannot be worked out; such calls are left
+// to fail when they run.
+func resolveOutgoing(ctx context.Context, tool string, request mcp.CallToolRequest) []*services.Email {
+	resolve, ok := outgoingMessages[tool]
+	if !ok {
+		return nil
+	}
+	messages, err := resolve(ctx, request)
+	if err != nil {
+		return nil
+	}
+	return messages
+}
+
+// checkSendPolicy fails when the send policy would refuse to send messages
+// for a call of tool, so it can be refused before asking for confirmation.
+func checkSendPolicy(ctx context.Context, tool string, messages []*services.Email) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	policy, err := sendPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	for _, email := range messages {
+		recipients, err := email.Recipients()
+		if err != nil {
+			return err
+		}
+		if err := policy.checkRecipients(recipients); err != nil {
+			return err
+		}
+	}
+	// A forwarding filter sends nothing yet, so no quota applies.
+	if policy.DryRun || tool == "gmail_create_filter" {
+		return nil
+	}
+	return policy.checkQuota(ctx)
+}
+
+// checkForwardTo applies the recipient rules of the send policy to the
+// forwarding address of a filter, which sends mail on from then on.
+func checkForwardTo(address string) error {
+	policy, err := sendPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	recipients, err := services.ParseAddressList(address)
+	if err != nil {
+		return fmt.Errorf("invalid forward_to: %w", err)
+	}
+	return policy.checkRecipients(recipients)
+}
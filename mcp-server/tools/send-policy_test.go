@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"testing"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+)
+
+func addresses(list ...string) []*mail.Address {
+	var out []*mail.Address
+	for _, a := range list {
+		out = append(out, &mail.Address{Address: a})
+	}
+	return out
+}
+
+func TestCheckRecipients(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     sendPolicy
+		recipients []*mail.Address
+		wantErr    bool
+	}{
+		{"no policy", sendPolicy{}, addresses("a@example.com", "b@other.com"), false},
+		{"within the cap", sendPolicy{MaxRecipients: 2}, addresses("a@example.com", "b@example.com"), false},
+		{"over the cap", sendPolicy{MaxRecipients: 2}, addresses("a@example.com", "b@example.com", "c@example.com"), true},
+		{"allowed address", sendPolicy{Allowed: []string{"a@example.com"}}, addresses("A@Example.com"), false},
+		{"allowed domain", sendPolicy{Allowed: []string{"@example.com"}}, addresses("a@example.com", "b@example.com"), false},
+		{"one outside the allow list", sendPolicy{Allowed: []string{"@example.com"}}, addresses("a@example.com", "b@other.com"), true},
+		{"subdomain is not the domain", sendPolicy{Allowed: []string{"@example.com"}}, addresses("a@mail.example.com"), true},
+		{"domain suffix is not the domain", sendPolicy{Allowed: []string{"@example.com"}}, addresses("a@badexample.com"), true},
+		{"blocked address", sendPolicy{Blocked: []string{"b@example.com"}}, addresses("a@example.com", "B@example.com"), true},
+		{"blocked domain", sendPolicy{Blocked: []string{"@other.com"}}, addresses("a@other.com"), true},
+		{"deny wins over allow", sendPolicy{Allowed: []string{"@example.com"}, Blocked: []string{"ceo@example.com"}}, addresses("ceo@example.com"), true},
+	}
+	for _, tt := range tests {
+		err := tt.policy.checkRecipients(tt.recipients)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkRecipients = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, errSendPolicy) {
+			t.Errorf("%s: %v does not wrap errSendPolicy", tt.name, err)
+		}
+	}
+}
+
+func TestSendPolicyFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    sendPolicy
+		wantErr bool
+	}{
+		{"unset", nil, sendPolicy{}, false},
+		{"limits", map[string]string{"SEND_MAX_RECIPIENTS": "5", "SEND_SESSION_QUOTA": "2", "SEND_DAILY_QUOTA": "10", "SEND_DRY_RUN": "true"},
+			sendPolicy{MaxRecipients: 5, SessionQuota: 2, DailyQuota: 10, DryRun: true}, false},
+		{"negative limit", map[string]string{"SEND_DAILY_QUOTA": "-1"}, sendPolicy{}, true},
+		{"non-numeric limit", map[string]string{"SEND_MAX_RECIPIENTS": "many"}, sendPolicy{}, true},
+		{"bad dry run", map[string]string{"SEND_DRY_RUN": "maybe"}, sendPolicy{}, true},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"SEND_ALLOW", "SEND_DENY", "SEND_MAX_RECIPIENTS", "SEND_SESSION_QUOTA", "SEND_DAILY_QUOTA", "SEND_DRY_RUN"} {
+			t.Setenv(name, tt.env[name])
+		}
+		got, err := sendPolicyFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: sendPolicyFromEnv error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (got.MaxRecipients != tt.want.MaxRecipients || got.SessionQuota != tt.want.SessionQuota ||
+			got.DailyQuota != tt.want.DailyQuota || got.DryRun != tt.want.DryRun) {
+			t.Errorf("%s: sendPolicyFromEnv = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	t.Setenv("SEND_DRY_RUN", "")
+	t.Setenv("SEND_ALLOW", "A@Example.com, @Partner.org")
+	if p, err := sendPolicyFromEnv(); err != nil || !addressMatches(p.Allowed, "x@partner.org") || !addressMatches(p.Allowed, "a@example.com") {
+		t.Errorf("SEND_ALLOW is not matched case insensitively: %+v, %v", p.Allowed, err)
+	}
+}
+
+func TestSendQuotas(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy sendPolicy
+		sends  int
+	}{
+		{"session quota", sendPolicy{SessionQuota: 2}, 2},
+		{"daily quota", sendPolicy{DailyQuota: 3}, 3},
+		{"smaller quota wins", sendPolicy{SessionQuota: 4, DailyQuota: 1}, 1},
+	}
+	for _, tt := range tests {
+		ctx := session.WithUser(context.Background(), "quota-"+tt.name)
+		for i := 0; i < tt.sends; i++ {
+			if _, err := tt.policy.reserveSend(ctx); err != nil {
+				t.Fatalf("%s: send %d refused: %v", tt.name, i+1, err)
+			}
+		}
+		if err := tt.policy.checkQuota(ctx); !errors.Is(err, errSendQuota) {
+			t.Errorf("%s: checkQuota after %d sends = %v, want errSendQuota", tt.name, tt.sends, err)
+		}
+		if _, err := tt.policy.reserveSend(ctx); !errors.Is(err, errSendQuota) {
+			t.Errorf("%s: send %d = %v, want errSendQuota", tt.name, tt.sends+1, err)
+		}
+	}
+
+	// A failed send gives its reservation back.
+	ctx := session.WithUser(context.Background(), "quota-release")
+	policy := sendPolicy{SessionQuota: 1, DailyQuota: 1}
+	release, err := policy.reserveSend(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if err := policy.checkQuota(ctx); err != nil {
+		t.Errorf("checkQuota after a released send = %v, want nil", err)
+	}
+
+	// The daily count from another day does not carry over.
+	quotaMu.Lock()
+	dailySends["quota-yesterday"] = dailyCount{day: "2000-01-01", n: 5}
+	quotaMu.Unlock()
+	ctx = session.WithUser(context.Background(), "quota-yesterday")
+	if err := (sendPolicy{DailyQuota: 5}).checkQuota(ctx); err != nil {
+		t.Errorf("checkQuota with yesterday's count = %v, want nil", err)
+	}
+}
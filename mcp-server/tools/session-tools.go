@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/services"
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+	"github.com/dongsinhho/ai-repos/mcp-server/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func RegisterSessionTools(s ToolAdder) {
+	// Set API key tool
+	setKeyTool := mcp.NewTool("set_api_key",
+		mcp.WithDescription("Store a credential for the current user and session. The value is never returned by any tool"),
+		mcp.WithString("service", mcp.Required(), mcp.Enum(session.Services...),
+			mcp.Description("Service the credential belongs to (google_token expects the OAuth token JSON, "+
+				"google_account the Workspace address the server's service account should act as)")),
+		mcp.WithString("api_key", mcp.Required(), mcp.Description("Secret value: API key, token JSON, profile name or email address")),
+		mcp.WithNumber("ttl_minutes", mcp.Description("Forget the credential after this many minutes (default: never)")),
+	)
+	s.AddTool(setKeyTool, utils.ErrorGuard(setAPIKeyHandler))
+
+	// Clear API key tool
+	clearKeyTool := mcp.NewTool("clear_api_key",
+		mcp.WithDescription("Remove a stored credential for the current user"),
+		mcp.WithString("service", mcp.Required(), mcp.Enum(session.Services...), mcp.Description("Service to clear")),
+	)
+	s.AddTool(clearKeyTool, utils.ErrorGuard(clearAPIKeyHandler))
+
+	// List API keys tool
+	listKeysTool := mcp.NewTool("list_api_keys",
+		mcp.WithDescription("List which services have a stored credential for the current user (values are not shown)"),
+		readOnlyAnnotation,
+	)
+	s.AddTool(listKeysTool, utils.ErrorGuard(listAPIKeysHandler))
+}
+
+func setAPIKeyHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	service, ok := request.Params.Arguments["service"].(string)
+	if !ok {
+		return mcp.NewToolResultError("service must be a string"), nil
+	}
+	value, ok := request.Params.Arguments["api_key"].(string)
+	if !ok {
+		return mcp.NewToolResultError("api_key must be a string"), nil
+	}
+
+	ttl := time.Duration(mcp.ParseInt64(request, "ttl_minutes", 0)) * time.Minute
+
+	value = strings.TrimSpace(value)
+	if service == session.ServiceGoogleAccount {
+		if delegation := services.DelegationFromEnv(); !delegation.Allows(value) {
+			return mcp.NewToolResultError(fmt.Sprintf("%s may not be impersonated: it is not covered by GOOGLE_DELEGATION_ALLOW", value)), nil
+		}
+	}
+
+	if err := session.Set(ctx, service, value, ttl); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to set credential: %v", err)), nil
+	}
+	if service == session.ServiceGoogleToken || service == session.ServiceGoogleAccount {
+		forgetGoogleClient(ctx)
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("Credential for %s stored.", service)), nil
+}
+
+func clearAPIKeyHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	service, ok := request.Params.Arguments["service"].(string)
+	if !ok {
+		return mcp.NewToolResultError("service must be a string"), nil
+	}
+
+	cleared, err := session.Clear(ctx, service)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to clear credential: %v", err)), nil
+	}
+	if service == session.ServiceGoogleToken || service == session.ServiceGoogleAccount {
+		forgetGoogleClient(ctx)
+	}
+	if !cleared {
+		return mcp.NewToolResultText(fmt.Sprintf("No credential stored for %s.", service)), nil
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("Credential for %s cleared.", service)), nil
+}
+
+func listAPIKeysHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	services, err := session.List(ctx)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to list credentials: %v", err)), nil
+	}
+	if len(services) == 0 {
+		return mcp.NewToolResultText("No credentials stored."), nil
+	}
+
+	var result strings.Builder
+	result.WriteString("Stored credentials:\n")
+	for _, service := range services {
+		result.WriteString(fmt.Sprintf("- %s: set\n", service))
+	}
+	return mcp.NewToolResultText(result.String()), nil
+}
@@ -5,7 +5,6 @@ import (
 
 	"github.com/dongsinhho/ai-repos/mcp-server/utils"
 	"github.com/mark3labs/mcp-go/mcp"
-	"github.com/mark3labs/mcp-go/server"
 )
 
 // Browser tool request/response structs
@@ -88,7 +87,7 @@ func browserEvaluateHandler(ctx context.Context, request mcp.CallToolRequest) (*
 	return mcp.NewToolResultText("Evaluated script: " + script), nil
 }
 
-func RegisterWebTools(s *server.MCPServer) {
+func RegisterWebTools(s ToolAdder) {
 	// Open Page tool
 	openPageTool := mcp.NewTool("browser_open_page",
 		mcp.WithDescription("Open a web page in the browser"),
@@ -114,6 +113,7 @@ func RegisterWebTools(s *server.MCPServer) {
 	// Screenshot tool
 	screenshotTool := mcp.NewTool("browser_screenshot",
 		mcp.WithDescription("Take a screenshot of the page or an element"),
+		readOnlyAnnotation,
 		mcp.WithString("selector", mcp.Description("CSS selector to screenshot (optional)")),
 	)
 	s.AddTool(screenshotTool, utils.ErrorGuard(browserScreenshotHandler))
@@ -0,0 +1,283 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	mcpsession "github.com/dongsinhho/ai-repos/mcp-server/session"
+)
+
+const sessionHeader = "Mcp-Session-Id"
+
+// maxRequestBody bounds a POSTed message; inline attachments make the
+// largest ones.
+const maxRequestBody = 32 << 20
+
+// httpSession is a client session created by an initialize request on the
+// streamable HTTP transport.
+type httpSession struct {
+	id            string
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+	// lastUsed is when a request for the session last started or
+	// finished, in Unix nanoseconds, and busy the number of requests in
+	// flight and open event streams.
+	lastUsed atomic.Int64
+	busy     atomic.Int32
+}
+
+// begin marks a request for the session as started; the returned function
+// marks it as finished.
+func (s *httpSession) begin() func() {
+	s.busy.Add(1)
+	s.lastUsed.Store(time.Now().UnixNano())
+	return func() {
+		s.lastUsed.Store(time.Now().UnixNano())
+		s.busy.Add(-1)
+	}
+}
+
+func (s *httpSession) SessionID() string {
+	return s.id
+}
+
+func (s *httpSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *httpSession) Initialize() {
+	s.initialized.Store(true)
+}
+
+func (s *httpSession) Initialized() bool {
+	return s.initialized.Load()
+}
+
+var _ server.ClientSession = (*httpSession)(nil)
+
+// StreamableHTTPServer serves MCP over a single HTTP endpoint: clients POST
+// JSON-RPC messages and may open a GET event stream for server notifications.
+type StreamableHTTPServer struct {
+	server     *server.MCPServer
+	endpoint   string
+	userHeader string
+	sessions   sync.Map
+	// idleTimeout ends sessions without requests or open event streams
+	// for that long; zero keeps them until the client deletes them.
+	idleTimeout time.Duration
+}
+
+// NewStreamableHTTPServer creates a streamable HTTP transport mounted at
+// basePath + "/mcp".
+func NewStreamableHTTPServer(s *server.MCPServer, basePath string) *StreamableHTTPServer {
+	basePath = strings.TrimSuffix(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	return &StreamableHTTPServer{
+		server:   s,
+		endpoint: basePath + "/mcp",
+	}
+}
+
+// Endpoint returns the path the transport is served on.
+func (s *StreamableHTTPServer) Endpoint() string {
+	return s.endpoint
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *StreamableHTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != s.endpoint {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		s.handlePost(w, r)
+	case http.MethodGet:
+		s.handleStream(w, r)
+	case http.MethodDelete:
+		s.handleDelete(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *StreamableHTTPServer) handlePost(w http.ResponseWriter, r *http.Request) {
+	var rawMessage json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&rawMessage); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONRPCError(w, http.StatusRequestEntityTooLarge, nil, mcp.INVALID_REQUEST,
+				fmt.Sprintf("Message larger than %d bytes", maxRequestBody))
+			return
+		}
+		writeJSONRPCError(w, http.StatusBadRequest, nil, mcp.PARSE_ERROR, "Parse error")
+		return
+	}
+
+	var baseMessage struct {
+		Method mcp.MCPMethod `json:"method"`
+		ID     any           `json:"id,omitempty"`
+	}
+	if err := json.Unmarshal(rawMessage, &baseMessage); err != nil {
+		writeJSONRPCError(w, http.StatusBadRequest, nil, mcp.PARSE_ERROR, "Parse error")
+		return
+	}
+
+	var session *httpSession
+	if baseMessage.Method == mcp.MethodInitialize {
+		session = &httpSession{
+			id:            uuid.New().String(),
+			notifications: make(chan mcp.JSONRPCNotification, 100),
+		}
+		if err := s.server.RegisterSession(r.Context(), session); err != nil {
+			writeJSONRPCError(w, http.StatusInternalServerError, baseMessage.ID, mcp.INTERNAL_ERROR,
+				fmt.Sprintf("Session registration failed: %v", err))
+			return
+		}
+		session.lastUsed.Store(time.Now().UnixNano())
+		s.sessions.Store(session.id, session)
+		defer session.begin()()
+	} else {
+		var ok bool
+		session, ok = s.lookupSession(r)
+		if !ok {
+			writeJSONRPCError(w, http.StatusNotFound, baseMessage.ID, mcp.INVALID_REQUEST, "Invalid or missing session ID")
+			return
+		}
+		// Long tool calls keep the session from expiring under them.
+		defer session.begin()()
+	}
+
+	ctx := s.server.WithContext(r.Context(), session)
+	if s.userHeader != "" {
+		ctx = mcpsession.WithUser(ctx, r.Header.Get(s.userHeader))
+	}
+	response := s.server.HandleMessage(ctx, rawMessage)
+
+	w.Header().Set(sessionHeader, session.id)
+	if response == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleStream delivers server-initiated notifications for a session as
+// server-sent events until the client disconnects.
+func (s *StreamableHTTPServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(r)
+	if !ok {
+		http.Error(w, "Invalid or missing session ID", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set(sessionHeader, session.id)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	defer session.begin()()
+
+	for {
+		select {
+		case notification := <-session.notifications:
+			eventData, err := json.Marshal(notification)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", eventData)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *StreamableHTTPServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(r)
+	if !ok {
+		http.Error(w, "Invalid or missing session ID", http.StatusNotFound)
+		return
+	}
+	s.endSession(r.Context(), session.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// endSession forgets a session and runs the server's unregister hooks, once
+// even when a DELETE and the idle expiry race.
+func (s *StreamableHTTPServer) endSession(ctx context.Context, id string) {
+	if _, ok := s.sessions.LoadAndDelete(id); ok {
+		s.server.UnregisterSession(ctx, id)
+	}
+}
+
+// expireIdleSessions calls expireIdle every interval, as clients that go
+// away rarely send a DELETE.
+func (s *StreamableHTTPServer) expireIdleSessions(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		s.expireIdle(now)
+	}
+}
+
+// expireIdle ends the sessions that have had no request in flight and no
+// open event stream for longer than idleTimeout.
+func (s *StreamableHTTPServer) expireIdle(now time.Time) {
+	s.sessions.Range(func(key, value any) bool {
+		session := value.(*httpSession)
+		idle := now.Sub(time.Unix(0, session.lastUsed.Load()))
+		if session.busy.Load() == 0 && idle > s.idleTimeout {
+			log.Printf("Ending streamable HTTP session %s after %s idle", session.id, idle.Round(time.Second))
+			s.endSession(context.Background(), session.id)
+		}
+		return true
+	})
+}
+
+func (s *StreamableHTTPServer) lookupSession(r *http.Request) (*httpSession, bool) {
+	sessionID := r.Header.Get(sessionHeader)
+	if sessionID == "" {
+		return nil, false
+	}
+	value, ok := s.sessions.Load(sessionID)
+	if !ok {
+		return nil, false
+	}
+	session := value.(*httpSession)
+	session.lastUsed.Store(time.Now().UnixNano())
+	return session, true
+}
+
+func writeJSONRPCError(w http.ResponseWriter, status int, id any, code int, message string) {
+	response := mcp.JSONRPCError{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      id,
+	}
+	response.Error.Code = code
+	response.Error.Message = message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
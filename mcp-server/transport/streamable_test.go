@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const initializeRequest = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`
+
+// testServer serves a streamable HTTP transport whose MCP server has a
+// "wait" tool that blocks until release is closed. ended collects the IDs
+// of unregistered sessions.
+type testServer struct {
+	h       *StreamableHTTPServer
+	http    *httptest.Server
+	release chan struct{}
+	mu      sync.Mutex
+	ended   []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := &testServer{release: make(chan struct{})}
+	hooks := &server.Hooks{}
+	hooks.AddOnUnregisterSession(func(ctx context.Context, cs server.ClientSession) {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		ts.ended = append(ts.ended, cs.SessionID())
+	})
+	s := server.NewMCPServer("test", "1.0.0", server.WithHooks(hooks))
+	s.AddTool(mcp.NewTool("wait"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		<-ts.release
+		return mcp.NewToolResultText("done"), nil
+	})
+	ts.h = NewStreamableHTTPServer(s, "/base")
+	ts.http = httptest.NewServer(ts.h)
+	t.Cleanup(ts.http.Close)
+	return ts
+}
+
+func (ts *testServer) endedSessions() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]string(nil), ts.ended...)
+}
+
+func (ts *testServer) do(t *testing.T, method, sessionID, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.http.URL+"/base/mcp", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionID != "" {
+		req.Header.Set(sessionHeader, sessionID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (ts *testServer) initialize(t *testing.T) string {
+	t.Helper()
+	resp := ts.do(t, http.MethodPost, "", initializeRequest)
+	id := resp.Header.Get(sessionHeader)
+	if resp.StatusCode != http.StatusOK || id == "" {
+		t.Fatalf("initialize = %d with session %q", resp.StatusCode, id)
+	}
+	return id
+}
+
+func TestStreamableSessionLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	id := ts.initialize(t)
+
+	if resp := ts.do(t, http.MethodPost, id, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("tools/list = %d, want 200", resp.StatusCode)
+	}
+	if resp := ts.do(t, http.MethodPost, id, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("notification = %d, want 202", resp.StatusCode)
+	}
+	if resp := ts.do(t, http.MethodPost, "", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("request without a session = %d, want 404", resp.StatusCode)
+	}
+	if resp := ts.do(t, http.MethodPost, id, `{not json`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed request = %d, want 400", resp.StatusCode)
+	}
+
+	if resp := ts.do(t, http.MethodDelete, id, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %d, want 204", resp.StatusCode)
+	}
+	if ended := ts.endedSessions(); len(ended) != 1 || ended[0] != id {
+		t.Errorf("ended sessions = %v, want %s", ended, id)
+	}
+	if resp := ts.do(t, http.MethodPost, id, `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("request after DELETE = %d, want 404", resp.StatusCode)
+	}
+	if resp := ts.do(t, http.MethodDelete, id, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second DELETE = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestStreamableIdleExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	ts.h.idleTimeout = time.Minute
+	idle, busy := ts.initialize(t), ts.initialize(t)
+
+	// A tool call in flight keeps its session alive however long it takes.
+	done := make(chan int)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, ts.http.URL+"/base/mcp",
+			strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"wait"}}`))
+		req.Header.Set(sessionHeader, busy)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	value, _ := ts.h.sessions.Load(busy)
+	for value.(*httpSession).busy.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ts.h.expireIdle(time.Now().Add(30 * time.Second))
+	if ended := ts.endedSessions(); len(ended) != 0 {
+		t.Fatalf("sessions ended before the timeout: %v", ended)
+	}
+	ts.h.expireIdle(time.Now().Add(2 * time.Minute))
+	if ended := ts.endedSessions(); len(ended) != 1 || ended[0] != idle {
+		t.Errorf("ended sessions = %v, want only %s", ended, idle)
+	}
+	if resp := ts.do(t, http.MethodPost, idle, `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("request to an expired session = %d, want 404", resp.StatusCode)
+	}
+
+	close(ts.release)
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("tool call = %d, want 200", status)
+	}
+	// Finishing the call counts as use.
+	ts.h.expireIdle(time.Now().Add(30 * time.Second))
+	if ended := ts.endedSessions(); len(ended) != 1 {
+		t.Errorf("session ended right after its call finished: %v", ended)
+	}
+}
+
+func TestStreamableRequestTooLarge(t *testing.T) {
+	ts := newTestServer(t)
+	id := ts.initialize(t)
+	body := `{"jsonrpc":"2.0","id":2,"method":"tools/list","params":{"pad":"` + strings.Repeat("x", maxRequestBody) + `"}}`
+	if resp := ts.do(t, http.MethodPost, id, body); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized request = %d, want 413", resp.StatusCode)
+	}
+}
+
+// readEvent returns the data of the next server-sent event.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			return strings.TrimSpace(data)
+		}
+	}
+}
+
+func TestStreamableStreamResumption(t *testing.T) {
+	ts := newTestServer(t)
+	id := ts.initialize(t)
+	value, _ := ts.h.sessions.Load(id)
+	session := value.(*httpSession)
+	notify := func(method string) {
+		session.notifications <- mcp.JSONRPCNotification{JSONRPC: mcp.JSONRPC_VERSION, Notification: mcp.Notification{Method: method}}
+	}
+
+	open := func() (*bufio.Reader, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.http.URL+"/base/mcp", nil)
+		req.Header.Set(sessionHeader, id)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("GET = %d %s, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body), func() { cancel(); resp.Body.Close() }
+	}
+
+	stream, closeStream := open()
+	notify("notifications/first")
+	if data := readEvent(t, stream); !strings.Contains(data, "notifications/first") {
+		t.Errorf("first event = %s", data)
+	}
+	closeStream()
+	for session.busy.Load() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Notifications sent between streams wait for the client to reconnect.
+	notify("notifications/second")
+	stream, closeStream = open()
+	defer closeStream()
+	if data := readEvent(t, stream); !strings.Contains(data, "notifications/second") {
+		t.Errorf("event after reconnecting = %s", data)
+	}
+
+	ts.h.idleTimeout = time.Minute
+	ts.h.expireIdle(time.Now().Add(time.Hour))
+	if ended := ts.endedSessions(); len(ended) != 0 {
+		t.Errorf("a session with an open stream expired: %v", ended)
+	}
+}
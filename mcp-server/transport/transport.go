@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/dongsinhho/ai-repos/mcp-server/session"
+)
+
+const (
+	Stdio          = "stdio"
+	SSE            = "sse"
+	StreamableHTTP = "http"
+)
+
+// Config selects how the MCP server is exposed to clients.
+type Config struct {
+	// Transport is one of Stdio, SSE or StreamableHTTP.
+	Transport string
+	// Addr is the listen address for the HTTP based transports, e.g. ":8082".
+	Addr string
+	// BaseURL is the externally visible URL, used by SSE to advertise the message endpoint.
+	BaseURL string
+	// BasePath is prefixed to every HTTP endpoint, e.g. "/mcp-server".
+	BasePath string
+	// UserHeader names a request header, set by a trusted gateway, that
+	// identifies the caller for per-user credentials. Empty disables it.
+	UserHeader string
+	// SessionIdleTimeout ends streamable HTTP sessions idle for longer.
+	// Zero disables the expiry.
+	SessionIdleTimeout time.Duration
+}
+
+// Serve runs the MCP server on the configured transport until it stops.
+func Serve(s *server.MCPServer, cfg Config) error {
+	switch cfg.Transport {
+	case Stdio:
+		return server.ServeStdio(s)
+	case SSE:
+		opts := []server.SSEOption{server.WithBaseURL(cfg.BaseURL)}
+		if cfg.BasePath != "" {
+			opts = append(opts, server.WithBasePath(cfg.BasePath))
+		}
+		if cfg.UserHeader != "" {
+			opts = append(opts, server.WithSSEContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+				return session.WithUser(ctx, r.Header.Get(cfg.UserHeader))
+			}))
+		}
+		sse := server.NewSSEServer(s, opts...)
+		log.Printf("SSE server listening on %s (%s)", cfg.Addr, sse.CompleteSseEndpoint())
+		return sse.Start(cfg.Addr)
+	case StreamableHTTP:
+		h := NewStreamableHTTPServer(s, cfg.BasePath)
+		h.userHeader = cfg.UserHeader
+		if cfg.SessionIdleTimeout > 0 {
+			h.idleTimeout = cfg.SessionIdleTimeout
+			go h.expireIdleSessions(max(cfg.SessionIdleTimeout/10, time.Second))
+		}
+		log.Printf("Streamable HTTP server listening on %s%s", cfg.Addr, h.Endpoint())
+		return http.ListenAndServe(cfg.Addr, h)
+	default:
+		return fmt.Errorf("unknown transport %q (expected %s, %s or %s)", cfg.Transport, Stdio, SSE, StreamableHTTP)
+	}
+}